@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"unicode/utf8"
+)
+
+// Aggregate types a metric query may compute. count, sum, min and max are
+// stored as INT in the count column; avg is stored as DECIMAL in the value
+// column.
+const (
+	aggregateCount = "count"
+	aggregateSum   = "sum"
+	aggregateAvg   = "avg"
+	aggregateMin   = "min"
+	aggregateMax   = "max"
+)
+
+func validAggregate(a string) bool {
+	switch a {
+	case "", aggregateCount, aggregateSum, aggregateAvg, aggregateMin, aggregateMax:
+		return true
+	}
+	return false
+}
+
+// aggregate returns the metric's aggregate type, count by default.
+func (m MetricQuery) aggregate() string {
+	if m.Aggregate == "" {
+		return aggregateCount
+	}
+	return m.Aggregate
+}
+
+// Value types a metric may have.
+const (
+	typeInt    = "int"
+	typeFloat  = "float"
+	typeString = "string"
+)
+
+// maxStringValue is the width of the VARCHAR column string metrics go to.
+const maxStringValue = 255
+
+func validType(t string) bool {
+	return t == "" || t == typeInt || t == typeFloat || t == typeString
+}
+
+// isString reports whether the metric's value is a string stored in a
+// VARCHAR value column.
+func (m MetricQuery) isString() bool {
+	return m.Type == typeString
+}
+
+// isFloat reports whether the metric's value is fractional and stored in a
+// DECIMAL value column: either it is declared type: float or it is an avg.
+func (m MetricQuery) isFloat() bool {
+	return m.Type == typeFloat || m.aggregate() == aggregateAvg
+}
+
+// queryMetricValue runs the metric query against every source and combines
+// the results according to the metric's aggregate: counts and sums are
+// added, minimums and maximums are taken across sources. Integer results are
+// returned as count; fractional ones as value.
+func queryMetricValue(ctx context.Context, dbs []*sql.DB, m MetricQuery, query string, args ...any) (int, any, error) {
+	if m.isString() {
+		if len(dbs) > 1 {
+			return 0, nil, errors.New("string metrics cannot be combined across several -pgDsns sources")
+		}
+		v, err := retryQueryScalar[string](ctx, dbs[0], query, *maxRetries, *retryDelay, args...)
+		if err != nil {
+			return 0, nil, err
+		}
+		// VARCHAR(255) counts characters, not bytes.
+		if n := utf8.RuneCountInString(v); n > maxStringValue {
+			return 0, nil, fmt.Errorf("string value is %d characters, longer than %d", n, maxStringValue)
+		}
+		return 0, v, nil
+	}
+	if m.isFloat() {
+		if m.aggregate() == aggregateAvg && len(dbs) > 1 {
+			return 0, nil, errors.New("avg metrics cannot be combined across several -pgDsns sources")
+		}
+		values, err := queryAllSources[float64](ctx, dbs, query, args...)
+		if err != nil {
+			return 0, nil, err
+		}
+		v := combineSources(m.aggregate(), values)
+		if m.Scale > 0 {
+			p := math.Pow10(m.Scale)
+			v = math.Round(v*p) / p
+		}
+		return 0, v, nil
+	}
+
+	values, err := queryAllSources[int64](ctx, dbs, query, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(combineSources(m.aggregate(), values)), nil, nil
+}
+
+// combineSources folds the per-source values of one metric into one.
+func combineSources[T int64 | float64](aggregate string, values []T) T {
+	combined := values[0]
+	for _, v := range values[1:] {
+		switch aggregate {
+		case aggregateMin:
+			combined = min(combined, v)
+		case aggregateMax:
+			combined = max(combined, v)
+		default:
+			combined += v
+		}
+	}
+	return combined
+}
+
+// combinableMetrics drops the metrics checkAggregateSources would reject
+// for the given number of sources. It is applied to the built-in set only;
+// a metrics file that asks for such a metric is an error.
+func combinableMetrics(metrics []MetricQuery, sources int) []MetricQuery {
+	if sources < 2 {
+		return metrics
+	}
+	var kept []MetricQuery
+	for _, m := range metrics {
+		if m.aggregate() == aggregateAvg || m.isString() {
+			slog.Warn("Skipping built-in metric that cannot be combined across sources", "metric_name", m.Name, "sources", sources)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// checkAggregateSources rejects metrics whose aggregate cannot be combined
+// across the configured number of PostgreSQL sources.
+func checkAggregateSources(metrics []MetricQuery, sources int) error {
+	if sources < 2 {
+		return nil
+	}
+	for _, m := range metrics {
+		if m.aggregate() == aggregateAvg {
+			return fmt.Errorf("metric %s: avg cannot be combined across %d -pgDsns sources", m.Name, sources)
+		}
+		if m.isString() {
+			return fmt.Errorf("metric %s: string metrics cannot be combined across %d -pgDsns sources", m.Name, sources)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"oula-transfer/internal/transfer"
+)
+
+// newMockDB returns a sqlmock database that matches statements literally.
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func TestFloatMetricRoundTrip(t *testing.T) {
+	const query = "SELECT AVG(reward) FROM reward WHERE date = $1::date"
+	m := MetricQuery{Name: "avg_reward", Query: query, MySQLTable: "avg_reward", Type: typeFloat}
+
+	for _, want := range []float64{0.00000001, 1.23456789, 12345678901.12345678, 0.1 + 0.2} {
+		pg, pgMock := newMockDB(t)
+		my, myMock := newMockDB(t)
+		pgMock.ExpectQuery(query).WithArgs("2024-01-15").
+			WillReturnRows(sqlmock.NewRows([]string{"avg"}).AddRow(want))
+		myMock.ExpectBegin()
+		myMock.ExpectExec("INSERT INTO avg_reward (date, value) VALUES (?, ?)").
+			WithArgs("2024-01-15", want).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		myMock.ExpectCommit()
+
+		count, value, err := queryMetricValue(context.Background(), []*sql.DB{pg}, m, query, "2024-01-15")
+		if err != nil {
+			t.Fatalf("queryMetricValue: %v", err)
+		}
+		if count != 0 || value != want {
+			t.Fatalf("queryMetricValue = (%d, %v), want (0, %v)", count, value, want)
+		}
+		r := transfer.Result{Name: m.Name, Table: m.MySQLTable, Value: value}
+		if err := mysqlWriter().InsertAll(context.Background(), my, "2024-01-15", []transfer.Result{r}); err != nil {
+			t.Fatalf("InsertAll: %v", err)
+		}
+		for _, mock := range []sqlmock.Sqlmock{pgMock, myMock} {
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("%v: %v", want, err)
+			}
+		}
+	}
+}
+
+func TestFloatMetricScale(t *testing.T) {
+	const query = "SELECT AVG(machine_count) FROM sub"
+	tests := []struct {
+		scale     int
+		wantValue float64
+		wantType  string
+	}{
+		{0, 1.23456, "DECIMAL(20,8)"},
+		{2, 1.23, "DECIMAL(10,2)"},
+		{4, 1.2346, "DECIMAL(10,4)"},
+	}
+	for _, tt := range tests {
+		m := MetricQuery{Name: "avg_machines", Query: query, MySQLTable: "avg_machines", Type: typeFloat, Scale: tt.scale}
+		if err := validateMetricQueries([]MetricQuery{m}); err != nil {
+			t.Fatalf("scale %d: validateMetricQueries: %v", tt.scale, err)
+		}
+
+		tables, err := schemaTables([]MetricQuery{m}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ddl := tables[0].DDL; !strings.Contains(ddl, "value "+tt.wantType+" NOT NULL") {
+			t.Errorf("scale %d: DDL = %q, want a %s value column", tt.scale, ddl, tt.wantType)
+		}
+
+		pg, pgMock := newMockDB(t)
+		pgMock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"avg"}).AddRow(1.23456))
+		_, value, err := queryMetricValue(context.Background(), []*sql.DB{pg}, m, query)
+		if err != nil {
+			t.Fatalf("scale %d: queryMetricValue: %v", tt.scale, err)
+		}
+		if value != tt.wantValue {
+			t.Errorf("scale %d: value = %v, want %v", tt.scale, value, tt.wantValue)
+		}
+	}
+}
+
+func TestCombineSources(t *testing.T) {
+	ints := []int64{3, 10, 7}
+	floats := []float64{0.25, 1.5, 0.125}
+	tests := []struct {
+		aggregate string
+		wantInt   int64
+		wantFloat float64
+	}{
+		{aggregateCount, 20, 1.875},
+		{aggregateSum, 20, 1.875},
+		{aggregateMin, 3, 0.125},
+		{aggregateMax, 10, 1.5},
+	}
+	for _, tt := range tests {
+		if got := combineSources(tt.aggregate, ints); got != tt.wantInt {
+			t.Errorf("combineSources(%s, %v) = %d, want %d", tt.aggregate, ints, got, tt.wantInt)
+		}
+		if got := combineSources(tt.aggregate, floats); got != tt.wantFloat {
+			t.Errorf("combineSources(%s, %v) = %v, want %v", tt.aggregate, floats, got, tt.wantFloat)
+		}
+	}
+}
+
+func TestStringMetricLength(t *testing.T) {
+	const query = "SELECT name FROM miner ORDER BY commits DESC LIMIT 1"
+	m := MetricQuery{Name: "top_miner", Query: query, MySQLTable: "top_miner", Type: typeString}
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"ascii", "miner-01", false},
+		{"255 multi-byte characters", strings.Repeat("矿", maxStringValue), false},
+		{"256 characters", strings.Repeat("a", maxStringValue+1), true},
+		{"256 multi-byte characters", strings.Repeat("矿", maxStringValue+1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pg, mock := newMockDB(t)
+			mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(tt.value))
+			_, value, err := queryMetricValue(context.Background(), []*sql.DB{pg}, m, query)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("queryMetricValue succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("queryMetricValue: %v", err)
+			}
+			if value != tt.value {
+				t.Errorf("value = %q, want %q", value, tt.value)
+			}
+		})
+	}
+}
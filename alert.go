@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+const alertTimeout = 10 * time.Second
+
+// alertRetryDelay is the pause before the second attempt; tests shorten it.
+var alertRetryDelay = 5 * time.Second
+
+// alertPayload is the JSON body POSTed to -alertWebhook.
+type alertPayload struct {
+	Event         string   `json:"event"`
+	Timestamp     string   `json:"timestamp"`
+	Date          string   `json:"date,omitempty"`
+	Error         string   `json:"error"`
+	FailedMetrics []string `json:"failed_metrics"`
+}
+
+// sendAlert POSTs payload to webhookURL, retrying once after a short pause if
+// the first attempt fails.
+func sendAlert(ctx context.Context, webhookURL string, payload alertPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	err = postJSON(ctx, webhookURL, body)
+	if err == nil {
+		return nil
+	}
+	slog.Warn("Alert webhook failed, retrying", "delay", alertRetryDelay.String(), "error", err)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(alertRetryDelay):
+	}
+	return postJSON(ctx, webhookURL, body)
+}
+
+// postJSON sends body to url, treating any non-2xx response as an error.
+func postJSON(ctx context.Context, url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// alertTransferFailed notifies -alertWebhook, if set, that a transfer failed.
+func alertTransferFailed(ctx context.Context, res transfer.Summary, err error) {
+	if *alertWebhook == "" {
+		return
+	}
+	payload := alertPayload{
+		Event:         "transfer_failed",
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		Date:          res.Date,
+		Error:         err.Error(),
+		FailedMetrics: res.FailedMetrics,
+	}
+	if payload.FailedMetrics == nil {
+		payload.FailedMetrics = []string{}
+	}
+	if err := sendAlert(ctx, *alertWebhook, payload); err != nil {
+		slog.Error("Failed to send alert", "error", err)
+	}
+}
+
+// checkThreshold reports an error when count is below threshold; a zero
+// threshold disables the check.
+func checkThreshold(name string, count int, threshold int) error {
+	if threshold > 0 && count < threshold {
+		return fmt.Errorf("metric %s: count %d is below the expected minimum %d", name, count, threshold)
+	}
+	return nil
+}
+
+// alertThresholdBreached notifies -alertWebhook, if set, that a metric came in
+// below its minExpectedValue.
+func alertThresholdBreached(ctx context.Context, date, name string, err error) {
+	if *alertWebhook == "" {
+		return
+	}
+	payload := alertPayload{
+		Event:         "threshold_breached",
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		Date:          date,
+		Error:         err.Error(),
+		FailedMetrics: []string{name},
+	}
+	if err := sendAlert(ctx, *alertWebhook, payload); err != nil {
+		slog.Error("Failed to send alert", "error", err)
+	}
+}
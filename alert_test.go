@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// statusSequence serves the given status codes in turn, repeating the last
+// one, and records the decoded request bodies.
+type statusSequence[T any] struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []T
+	headers  []http.Header
+}
+
+func (s *statusSequence[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body T
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies = append(s.bodies, body)
+	s.headers = append(s.headers, r.Header.Clone())
+	status := s.statuses[min(len(s.bodies), len(s.statuses))-1]
+	w.WriteHeader(status)
+}
+
+func (s *statusSequence[T]) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+func TestSendAlert(t *testing.T) {
+	alertRetryDelay = time.Millisecond
+	defer func() { alertRetryDelay = 5 * time.Second }()
+
+	payload := alertPayload{
+		Event:         "transfer_failed",
+		Timestamp:     "2024-01-15T23:00:05Z",
+		Date:          "2024-01-15",
+		Error:         "lost_users_count: query timed out",
+		FailedMetrics: []string{"lost_users_count"},
+	}
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantErr      bool
+	}{
+		{"accepted", []int{http.StatusOK}, 1, false},
+		{"no content", []int{http.StatusNoContent}, 1, false},
+		{"retried once", []int{http.StatusServiceUnavailable, http.StatusOK}, 2, false},
+		{"fails twice", []int{http.StatusInternalServerError}, 2, true},
+		{"client error is retried too", []int{http.StatusBadRequest, http.StatusAccepted}, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq := &statusSequence[alertPayload]{statuses: tt.statuses}
+			srv := httptest.NewServer(seq)
+			defer srv.Close()
+
+			err := sendAlert(context.Background(), srv.URL, payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendAlert error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := seq.requests(); got != tt.wantRequests {
+				t.Fatalf("webhook got %d requests, want %d", got, tt.wantRequests)
+			}
+			for i, body := range seq.bodies {
+				if !reflect.DeepEqual(body, payload) {
+					t.Errorf("request %d body = %+v, want %+v", i, body, payload)
+				}
+				if ct := seq.headers[i].Get("Content-Type"); ct != "application/json" {
+					t.Errorf("request %d Content-Type = %q", i, ct)
+				}
+			}
+		})
+	}
+}
+
+func TestSendAlertUnreachable(t *testing.T) {
+	alertRetryDelay = time.Millisecond
+	defer func() { alertRetryDelay = 5 * time.Second }()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	if err := sendAlert(context.Background(), url, alertPayload{Event: "transfer_failed"}); err == nil {
+		t.Fatal("sendAlert to a closed server succeeded")
+	}
+}
+
+func TestCheckThreshold(t *testing.T) {
+	tests := []struct {
+		count, threshold int
+		wantErr          bool
+	}{
+		{count: 10, threshold: 0},
+		{count: 0, threshold: 0},
+		{count: 10, threshold: 10},
+		{count: 9, threshold: 10, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := checkThreshold("active_machines_count_aleo", tt.count, tt.threshold); (err != nil) != tt.wantErr {
+			t.Errorf("checkThreshold(%d, %d) error = %v, wantErr %v", tt.count, tt.threshold, err, tt.wantErr)
+		}
+	}
+}
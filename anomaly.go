@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+// checkAnomaly compares newCount with the value stored in table for the day
+// before date and reports whether it changed by more than threshold percent.
+// A missing or zero previous value gives no baseline and is never anomalous.
+func checkAnomaly(ctx context.Context, db *sql.DB, table, date string, newCount int, threshold float64) (bool, error) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return false, err
+	}
+	previous := day.AddDate(0, 0, -1).Format("2006-01-02")
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var prevCount int
+	err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT count FROM %s WHERE date = ?", table), previous).Scan(&prevCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read previous value from %s: %w", table, transfer.ContextError(ctx, err))
+	}
+	if prevCount == 0 {
+		return false, nil
+	}
+
+	change := math.Abs(float64(newCount-prevCount)) / float64(prevCount) * 100
+	if change <= threshold {
+		return false, nil
+	}
+	slog.Warn("Metric changed sharply from the previous day", "table", table, "date", date,
+		"count", newCount, "previous_count", prevCount, "change_percent", math.Round(change*10)/10,
+		"threshold_percent", threshold)
+	return true, nil
+}
+
+// screenAnomalies runs checkAnomaly for every result. With -anomalyAction=fail
+// anomalous results are dropped and reported as errors; otherwise they are
+// only logged. A failed lookup is logged and never blocks the insert.
+func screenAnomalies(ctx context.Context, db *sql.DB, date string, results []transfer.Result) ([]transfer.Result, []error) {
+	if *anomalyThreshold <= 0 {
+		return results, nil
+	}
+	var kept []transfer.Result
+	var errs []error
+	for _, r := range results {
+		if r.Value != nil {
+			// Only count metrics have a previous count to compare with.
+			kept = append(kept, r)
+			continue
+		}
+		anomalous, err := checkAnomaly(ctx, db, r.Table, date, r.Count, *anomalyThreshold)
+		if err != nil {
+			slog.Warn("Anomaly check failed", "metric_name", r.Name, "date", date, "error", err)
+		}
+		if anomalous && *anomalyAction == "fail" {
+			errs = append(errs, fmt.Errorf("%s: count %d changed by more than %g%% from the previous day", r.Name, r.Count, *anomalyThreshold))
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept, errs
+}
+
+func validateAnomalyFlags(threshold float64, action string) error {
+	if threshold < 0 {
+		return fmt.Errorf("anomalyThreshold must not be negative, got %g", threshold)
+	}
+	if action != "warn" && action != "fail" {
+		return fmt.Errorf("anomalyAction must be warn or fail, got %q", action)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resultCache remembers metric query results for -enableCache, so a re-run
+// for the same day (after a crash, or a manual trigger) does not repeat
+// expensive PostgreSQL queries.
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResult
+}
+
+type cachedResult struct {
+	count   int
+	value   any
+	expires time.Time
+}
+
+var queryCache = &resultCache{entries: make(map[string]cachedResult)}
+
+// cacheKey identifies a query result by the rendered query, its arguments
+// (which carry the date) and the sources it was run against.
+func cacheKey(query string, args []any, sources []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%v\x00%s", query, args, strings.Join(sources, "\x00"))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedMetric returns the cached result for a query when -enableCache is on.
+func cachedMetric(query string, args []any, sources []string) (cachedResult, bool) {
+	if !*enableCache {
+		return cachedResult{}, false
+	}
+	return queryCache.get(cacheKey(query, args, sources))
+}
+
+func (c *resultCache) get(key string) (cachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return cachedResult{}, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return cachedResult{}, false
+	}
+	return e, true
+}
+
+func (c *resultCache) put(key string, count int, value any, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	// Drop expired entries so a long backfill does not grow the map forever.
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedResult{count: count, value: value, expires: now.Add(ttl)}
+}
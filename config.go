@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed config.example.yaml
+var sampleConfig embed.FS
+
+// Config mirrors the command-line flags so the binary can be configured from
+// a YAML or JSON file. Each field's yaml tag is the name of the flag it sets;
+// nil or empty fields leave the flag at its default.
+type Config struct {
+	LogFormat            string     `yaml:"logFormat" json:"logFormat"`
+	LogLevel             string     `yaml:"logLevel" json:"logLevel"`
+	ExecutionTime        stringList `yaml:"executionTime" json:"executionTime"`
+	Timezone             string     `yaml:"timezone" json:"timezone"`
+	Interval             string     `yaml:"interval" json:"interval"`
+	PgDsn                string     `yaml:"pgDsn" json:"pgDsn"`
+	PgDsns               stringList `yaml:"pgDsns" json:"pgDsns"`
+	MysqlDsn             string     `yaml:"mysqlDsn" json:"mysqlDsn"`
+	PgDsnFile            string     `yaml:"pgDsnFile" json:"pgDsnFile"`
+	MysqlDsnFile         string     `yaml:"mysqlDsnFile" json:"mysqlDsnFile"`
+	MysqlDsns            stringList `yaml:"mysqlDsns" json:"mysqlDsns"`
+	Metrics              stringList `yaml:"metrics" json:"metrics"`
+	ExcludeMetrics       stringList `yaml:"excludeMetrics" json:"excludeMetrics"`
+	PgSSLMode            string     `yaml:"pgSSLMode" json:"pgSSLMode"`
+	PgSSLCert            string     `yaml:"pgSSLCert" json:"pgSSLCert"`
+	PgSSLKey             string     `yaml:"pgSSLKey" json:"pgSSLKey"`
+	PgSSLRootCert        string     `yaml:"pgSSLRootCert" json:"pgSSLRootCert"`
+	MysqlTLSName         string     `yaml:"mysqlTLSName" json:"mysqlTLSName"`
+	MysqlTLSCert         string     `yaml:"mysqlTLSCert" json:"mysqlTLSCert"`
+	MysqlTLSKey          string     `yaml:"mysqlTLSKey" json:"mysqlTLSKey"`
+	MysqlTLSCA           string     `yaml:"mysqlTLSCA" json:"mysqlTLSCA"`
+	PgMaxOpenConns       *int       `yaml:"pgMaxOpenConns" json:"pgMaxOpenConns"`
+	PgMaxIdleConns       *int       `yaml:"pgMaxIdleConns" json:"pgMaxIdleConns"`
+	PgConnMaxLifetime    string     `yaml:"pgConnMaxLifetime" json:"pgConnMaxLifetime"`
+	PgConnMaxIdleTime    string     `yaml:"pgConnMaxIdleTime" json:"pgConnMaxIdleTime"`
+	MysqlMaxOpenConns    *int       `yaml:"mysqlMaxOpenConns" json:"mysqlMaxOpenConns"`
+	MysqlMaxIdleConns    *int       `yaml:"mysqlMaxIdleConns" json:"mysqlMaxIdleConns"`
+	MysqlConnMaxLifetime string     `yaml:"mysqlConnMaxLifetime" json:"mysqlConnMaxLifetime"`
+	MysqlConnMaxIdleTime string     `yaml:"mysqlConnMaxIdleTime" json:"mysqlConnMaxIdleTime"`
+	MetricsFile          string     `yaml:"metricsFile" json:"metricsFile"`
+	QueriesDir           string     `yaml:"queriesDir" json:"queriesDir"`
+	PgSchema             string     `yaml:"pgSchema" json:"pgSchema"`
+	ActiveWindow         string     `yaml:"activeWindow" json:"activeWindow"`
+	LostUserDays         *int       `yaml:"lostUserDays" json:"lostUserDays"`
+	Workers              *int       `yaml:"workers" json:"workers"`
+	EnableCache          *bool      `yaml:"enableCache" json:"enableCache"`
+	CacheTTL             string     `yaml:"cacheTTL" json:"cacheTTL"`
+	MaxRetries           *int       `yaml:"maxRetries" json:"maxRetries"`
+	RetryBaseDelay       string     `yaml:"retryBaseDelay" json:"retryBaseDelay"`
+	ConnectTimeout       string     `yaml:"connectTimeout" json:"connectTimeout"`
+	QueryTimeout         string     `yaml:"queryTimeout" json:"queryTimeout"`
+	MaxTransferDuration  string     `yaml:"maxTransferDuration" json:"maxTransferDuration"`
+	SlowQueryThreshold   string     `yaml:"slowQueryThreshold" json:"slowQueryThreshold"`
+	Explain              *bool      `yaml:"explain" json:"explain"`
+	MaxCount             *int       `yaml:"maxCount" json:"maxCount"`
+	FailOnZero           *bool      `yaml:"failOnZero" json:"failOnZero"`
+	AnomalyThreshold     *float64   `yaml:"anomalyThreshold" json:"anomalyThreshold"`
+	AnomalyAction        string     `yaml:"anomalyAction" json:"anomalyAction"`
+	Once                 *bool      `yaml:"once" json:"once"`
+	PidFile              string     `yaml:"pidFile" json:"pidFile"`
+	AdvisoryLockKey      *int64     `yaml:"advisoryLockKey" json:"advisoryLockKey"`
+	PingOnly             *bool      `yaml:"pingOnly" json:"pingOnly"`
+	Status               *bool      `yaml:"status" json:"status"`
+	JSON                 *bool      `yaml:"json" json:"json"`
+	StaleRunAfter        string     `yaml:"staleRunAfter" json:"staleRunAfter"`
+	CheckSchemaOnly      *bool      `yaml:"checkSchemaOnly" json:"checkSchemaOnly"`
+	CheckQueries         *bool      `yaml:"checkQueries" json:"checkQueries"`
+	Date                 string     `yaml:"date" json:"date"`
+	FromDate             string     `yaml:"fromDate" json:"fromDate"`
+	ToDate               string     `yaml:"toDate" json:"toDate"`
+	Upsert               *bool      `yaml:"upsert" json:"upsert"`
+	TablePrefix          string     `yaml:"tablePrefix" json:"tablePrefix"`
+	TableSuffix          string     `yaml:"tableSuffix" json:"tableSuffix"`
+	DlqPath              string     `yaml:"dlqPath" json:"dlqPath"`
+	ReplayDLQ            *bool      `yaml:"replayDLQ" json:"replayDLQ"`
+	LocalFallback        string     `yaml:"localFallback" json:"localFallback"`
+	AutoMigrate          *bool      `yaml:"autoMigrate" json:"autoMigrate"`
+	DryRun               *bool      `yaml:"dry-run" json:"dry-run"`
+	Simulate             *bool      `yaml:"simulate" json:"simulate"`
+	SimulateSeed         *int64     `yaml:"simulateSeed" json:"simulateSeed"`
+	CsvOutput            string     `yaml:"csvOutput" json:"csvOutput"`
+	CopyQuery            string     `yaml:"copyQuery" json:"copyQuery"`
+	CopyOutput           string     `yaml:"copyOutput" json:"copyOutput"`
+	JsonOutput           string     `yaml:"jsonOutput" json:"jsonOutput"`
+	JsonPretty           *bool      `yaml:"jsonPretty" json:"jsonPretty"`
+	S3Bucket             string     `yaml:"s3Bucket" json:"s3Bucket"`
+	S3Prefix             string     `yaml:"s3Prefix" json:"s3Prefix"`
+	S3Region             string     `yaml:"s3Region" json:"s3Region"`
+	S3Only               *bool      `yaml:"s3Only" json:"s3Only"`
+	InfluxURL            string     `yaml:"influxURL" json:"influxURL"`
+	InfluxToken          string     `yaml:"influxToken" json:"influxToken"`
+	InfluxOrg            string     `yaml:"influxOrg" json:"influxOrg"`
+	InfluxBucket         string     `yaml:"influxBucket" json:"influxBucket"`
+	RedisAddr            string     `yaml:"redisAddr" json:"redisAddr"`
+	RedisPassword        string     `yaml:"redisPassword" json:"redisPassword"`
+	KafkaBrokers         string     `yaml:"kafkaBrokers" json:"kafkaBrokers"`
+	KafkaTopic           string     `yaml:"kafkaTopic" json:"kafkaTopic"`
+	KafkaOnly            *bool      `yaml:"kafkaOnly" json:"kafkaOnly"`
+	OutputStdout         *bool      `yaml:"outputStdout" json:"outputStdout"`
+	Report               *bool      `yaml:"report" json:"report"`
+	ReportTemplate       string     `yaml:"reportTemplate" json:"reportTemplate"`
+	ReportWebhook        string     `yaml:"reportWebhook" json:"reportWebhook"`
+	MetricsAddr          *string    `yaml:"metricsAddr" json:"metricsAddr"`
+	PushgatewayURL       string     `yaml:"pushgatewayURL" json:"pushgatewayURL"`
+	HealthAddr           string     `yaml:"healthAddr" json:"healthAddr"`
+	OtelEndpoint         string     `yaml:"otelEndpoint" json:"otelEndpoint"`
+	OtelServiceName      string     `yaml:"otelServiceName" json:"otelServiceName"`
+	AlertWebhook         string     `yaml:"alertWebhook" json:"alertWebhook"`
+	SlackWebhook         string     `yaml:"slackWebhook" json:"slackWebhook"`
+	SlackOnSuccess       *bool      `yaml:"slackOnSuccess" json:"slackOnSuccess"`
+	DashboardURL         string     `yaml:"dashboardURL" json:"dashboardURL"`
+	// Tenants has no flag equivalent; see Tenant.
+	Tenants []Tenant `yaml:"tenants" json:"tenants"`
+}
+
+// configList is implemented by list flags whose config entries are appended
+// as they are, without the parsing applied to command-line values.
+type configList interface {
+	appendEntry(entry string)
+}
+
+// stringList accepts either a single string or a list of strings, so keys
+// that grew from one value to many keep accepting the old form.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// loadConfig reads a config file, choosing the decoder from the file
+// extension. Unknown keys are rejected so typos do not go unnoticed.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var cfg Config
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// applyConfig copies the values from cfg into the registered flags, skipping
+// any flag that was given explicitly on the command line.
+func applyConfig(cfg *Config) error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("yaml")
+		if explicit[name] || flag.Lookup(name) == nil {
+			continue
+		}
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.Pointer:
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		case field.Kind() == reflect.String && field.Len() == 0:
+			// Plain strings treat empty as unset; use *string when an
+			// empty value is meaningful.
+			continue
+		}
+		if field.Kind() == reflect.Slice {
+			list, whole := flag.Lookup(name).Value.(configList)
+			for j := 0; j < field.Len(); j++ {
+				entry := fmt.Sprint(field.Index(j).Interface())
+				if whole {
+					list.appendEntry(entry)
+					continue
+				}
+				if err := flag.Set(name, entry); err != nil {
+					return fmt.Errorf("invalid config value for %s: %w", name, err)
+				}
+			}
+			continue
+		}
+		if err := flag.Set(name, fmt.Sprint(field.Interface())); err != nil {
+			return fmt.Errorf("invalid config value for %s: %w", name, err)
+		}
+	}
+	tenants = cfg.Tenants
+	return nil
+}
+
+var (
+	pgSchemaPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	tableAffixPattern = regexp.MustCompile(`^[A-Za-z0-9_]*$`)
+)
+
+// validateFlags checks the effective configuration after flags and the
+// config file have been merged.
+func validateFlags() error {
+	// Tenants bring their own DSNs.
+	if len(tenants) > 0 {
+		if err := validateTenants(); err != nil {
+			return err
+		}
+	} else if *tenantName != "" {
+		return errors.New("tenant requires a tenants section in the config file")
+	}
+	if len(tenants) == 0 && *pgDsn == "" && len(pgDsnList) == 0 && !*simulate && !*statusFlag && !*replayDLQFlag {
+		return errors.New("PostgreSQL DSN must be provided (or use -simulate)")
+	}
+	if len(tenants) == 0 && *mysqlDsn == "" && len(mysqlDsnList) == 0 && !fileOutputEnabled() && !*s3Only && !*kafkaOnly && !*outputStdout && *influxURL == "" && *redisAddr == "" {
+		return errors.New("MySQL DSN must be provided (or use -csvOutput/-jsonOutput/-s3Only/-kafkaOnly/-outputStdout/-influxURL/-redisAddr)")
+	}
+	if *replayDLQFlag && *dlqPath == "" {
+		return errors.New("replayDLQ requires dlqPath")
+	}
+	if *localFallback != "" && len(mysqlDsnList) > 1 {
+		return errors.New("localFallback supports a single MySQL destination")
+	}
+	if *influxURL != "" && (*influxOrg == "" || *influxBucket == "") {
+		return errors.New("influxURL requires influxOrg and influxBucket")
+	}
+	if *kafkaBrokers != "" && *kafkaTopic == "" {
+		return errors.New("kafkaBrokers requires kafkaTopic")
+	}
+	if *kafkaOnly && *kafkaBrokers == "" {
+		return errors.New("kafkaOnly requires kafkaBrokers")
+	}
+	if *s3Only && *kafkaOnly {
+		return errors.New("s3Only and kafkaOnly are mutually exclusive")
+	}
+	if (*reportTemplate != "" || *reportWebhook != "") && !*reportFlag {
+		return errors.New("reportTemplate and reportWebhook require report")
+	}
+	if (*copyQuery == "") != (*copyOutput == "") {
+		return errors.New("copyQuery and copyOutput must be provided together")
+	}
+	if *s3Only && *s3Bucket == "" {
+		return errors.New("s3Only requires s3Bucket")
+	}
+	if *pgDsn != "" && len(pgDsnList) > 0 {
+		return errors.New("pgDsn and pgDsns are mutually exclusive")
+	}
+	if *mysqlDsn != "" && len(mysqlDsnList) > 0 {
+		return errors.New("mysqlDsn and mysqlDsns are mutually exclusive")
+	}
+	if *lostUserDays < 1 || *lostUserDays > 365 {
+		return fmt.Errorf("lostUserDays must be between 1 and 365, got %d", *lostUserDays)
+	}
+	if activeWindow.Seconds() < 1 {
+		return fmt.Errorf("activeWindow must be at least 1s, got %s", *activeWindow)
+	}
+	if *maxTransferDuration < 0 {
+		return fmt.Errorf("maxTransferDuration must not be negative, got %s", *maxTransferDuration)
+	}
+	if *staleRunAfter < 0 {
+		return fmt.Errorf("staleRunAfter must not be negative, got %s", *staleRunAfter)
+	}
+	if *interval < 0 {
+		return fmt.Errorf("interval must not be negative, got %s", *interval)
+	}
+	if *interval > 0 && len(executionTimes) > 0 {
+		return errors.New("interval and executionTime are mutually exclusive")
+	}
+	for _, t := range executionTimes.orDefault() {
+		if err := validateSchedule(t); err != nil {
+			return err
+		}
+	}
+	if *date != "" && (*fromDate != "" || *toDate != "") {
+		return errors.New("date cannot be combined with fromDate/toDate")
+	}
+	if *queriesDir != "" && *metricsFile != "" {
+		return errors.New("queriesDir and metricsFile are mutually exclusive")
+	}
+	for name, v := range map[string]string{"tablePrefix": *tablePrefix, "tableSuffix": *tableSuffix} {
+		if !tableAffixPattern.MatchString(v) {
+			return fmt.Errorf("%s %q may only contain letters, digits and underscores", name, v)
+		}
+	}
+	if !pgSchemaPattern.MatchString(*pgSchema) {
+		return fmt.Errorf("pgSchema %q must be a plain identifier matching %s", *pgSchema, pgSchemaPattern)
+	}
+	if _, err := loadTimezone(*timezone); err != nil {
+		return err
+	}
+	if err := pgTLSFromFlags().validate(); err != nil {
+		return err
+	}
+	if err := mysqlTLSFromFlags().validate(); err != nil {
+		return err
+	}
+	if err := pgPoolFromFlags().validate("PostgreSQL"); err != nil {
+		return err
+	}
+	if err := mysqlPoolFromFlags().validate("MySQL"); err != nil {
+		return err
+	}
+	if *workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", *workers)
+	}
+	if *enableCache && *cacheTTL <= 0 {
+		return fmt.Errorf("cacheTTL must be positive, got %s", *cacheTTL)
+	}
+	if *maxCount < 0 {
+		return fmt.Errorf("maxCount must not be negative, got %d", *maxCount)
+	}
+	if err := validateAnomalyFlags(*anomalyThreshold, *anomalyAction); err != nil {
+		return err
+	}
+	return validateRetryFlags(*maxRetries, *retryDelay)
+}
+
+func printSampleConfig() error {
+	data, err := sampleConfig.ReadFile("config.example.yaml")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestValidateFlagsDSNs(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		wantErr bool
+	}{
+		{"both DSNs", map[string]string{"pgDsn": "postgres://pg/oula", "mysqlDsn": "oula@tcp(mysql)/oula"}, false},
+		{"no PostgreSQL DSN", map[string]string{"mysqlDsn": "oula@tcp(mysql)/oula"}, true},
+		{"no MySQL DSN", map[string]string{"pgDsn": "postgres://pg/oula"}, true},
+		{"simulate", map[string]string{"simulate": "true", "mysqlDsn": "oula@tcp(mysql)/oula"}, false},
+		{"status", map[string]string{"status": "true", "mysqlDsn": "oula@tcp(mysql)/oula"}, false},
+		{"replayDLQ", map[string]string{"replayDLQ": "true", "dlqPath": "/tmp/dlq.db", "mysqlDsn": "oula@tcp(mysql)/oula"}, false},
+		{"replayDLQ without dlqPath", map[string]string{"replayDLQ": "true", "mysqlDsn": "oula@tcp(mysql)/oula"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"pgDsn", "mysqlDsn"} {
+				setFlag(t, name, "")
+			}
+			for name, value := range tt.flags {
+				setFlag(t, name, value)
+			}
+			if err := validateFlags(); (err != nil) != tt.wantErr {
+				t.Errorf("validateFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"oula-transfer/internal/transfer"
+)
+
+// copyFromPostgres streams the rows of query to dest as CSV with a header
+// line, using COPY ... TO STDOUT instead of scanning row by row. COPY
+// cannot take bind parameters, so query must be fully rendered. It returns the number of
+// rows copied.
+func copyFromPostgres(ctx context.Context, db *sql.DB, query string, dest io.Writer) (int64, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get a PostgreSQL connection: %w", err)
+	}
+	defer conn.Close()
+
+	var rows int64
+	err = conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("COPY requires a connection opened with the pgx driver")
+		}
+		tag, err := c.Conn().PgConn().CopyTo(ctx, dest, "COPY ("+query+") TO STDOUT WITH (FORMAT csv, HEADER)")
+		if err != nil {
+			return err
+		}
+		rows = tag.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy from PostgreSQL: %w", transfer.ContextError(ctx, err))
+	}
+	return rows, nil
+}
+
+// copyOutputPath replaces {{date}} in -copyOutput, so each day gets its own
+// file.
+func copyOutputPath(pattern, date string) string {
+	return strings.ReplaceAll(pattern, "{{date}}", date)
+}
+
+// exportCopyQuery streams -copyQuery from the PostgreSQL source at dsn into
+// the -copyOutput file for forDate. The file is written next to its final
+// path and renamed into place, so readers never see a partial export.
+func exportCopyQuery(ctx context.Context, dsn string, forDate time.Time) error {
+	day := forDate.Format("2006-01-02")
+	query := renderDate(renderQuery(*copyQuery, *pgSchema), forDate)
+	path := copyOutputPath(*copyOutput, day)
+
+	db, err := openPostgres(dsn, pgTLSFromFlags())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := copyFromPostgres(ctx, db, query, tmp)
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to write %s: %w", path, cerr)
+	}
+	if err != nil {
+		return redactDSN(err, dsn)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	slog.InfoContext(ctx, "Exported rows with COPY", "path", path, "date", day, "rows", rows)
+	return nil
+}
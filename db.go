@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"oula-transfer/internal/transfer"
+)
+
+// pgTLSConfig holds the TLS settings that override those in the PostgreSQL
+// DSN. Empty fields leave the DSN untouched.
+type pgTLSConfig struct {
+	SSLMode     string
+	SSLCert     string
+	SSLKey      string
+	SSLRootCert string
+}
+
+func pgTLSFromFlags() pgTLSConfig {
+	return pgTLSConfig{
+		SSLMode:     *pgSSLMode,
+		SSLCert:     *pgSSLCert,
+		SSLKey:      *pgSSLKey,
+		SSLRootCert: *pgSSLRootCert,
+	}
+}
+
+func (c pgTLSConfig) validate() error {
+	switch c.SSLMode {
+	case "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		return nil
+	}
+	return fmt.Errorf("invalid pgSSLMode %q", c.SSLMode)
+}
+
+func (c pgTLSConfig) params() [][2]string {
+	var params [][2]string
+	for _, p := range [][2]string{
+		{"sslmode", c.SSLMode},
+		{"sslcert", c.SSLCert},
+		{"sslkey", c.SSLKey},
+		{"sslrootcert", c.SSLRootCert},
+	} {
+		if p[1] != "" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// pgDriver and mysqlDriver are the database/sql drivers the handles are
+// opened with; tests swap in sqlmock.
+var (
+	pgDriver    = "pgx"
+	mysqlDriver = "mysql"
+)
+
+// openPostgres opens a PostgreSQL handle with the TLS options applied to dsn.
+func openPostgres(dsn string, opts pgTLSConfig) (*sql.DB, error) {
+	dsn, err := applyPgTLS(dsn, opts)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(pgDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", redactDSN(err, dsn))
+	}
+	return db, nil
+}
+
+// applyPgTLS sets the TLS parameters on either a URL or a key/value DSN,
+// replacing any value the DSN already carries.
+func applyPgTLS(dsn string, opts pgTLSConfig) (string, error) {
+	params := opts.params()
+	if len(params) == 0 {
+		return dsn, nil
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid PostgreSQL DSN: %w", redactDSN(err, dsn))
+		}
+		q := u.Query()
+		for _, p := range params {
+			q.Set(p[0], p[1])
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+	for _, p := range params {
+		dsn = regexp.MustCompile(`(?:^|\s)`+p[0]+`\s*=\s*('(?:[^'\\]|\\.)*'|\S*)`).ReplaceAllString(dsn, "")
+		dsn = strings.TrimSpace(dsn) + " " + p[0] + "='" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p[1]) + "'"
+	}
+	return strings.TrimSpace(dsn), nil
+}
+
+// mysqlTLSConfig describes a TLS configuration registered with the MySQL
+// driver under Name and referenced from the DSN's tls parameter.
+type mysqlTLSConfig struct {
+	Name string
+	Cert string
+	Key  string
+	CA   string
+}
+
+func mysqlTLSFromFlags() mysqlTLSConfig {
+	return mysqlTLSConfig{
+		Name: *mysqlTLSName,
+		Cert: *mysqlTLSCert,
+		Key:  *mysqlTLSKey,
+		CA:   *mysqlTLSCA,
+	}
+}
+
+func (c mysqlTLSConfig) validate() error {
+	hasFiles := c.Cert != "" || c.Key != "" || c.CA != ""
+	if hasFiles && c.Name == "" {
+		return errors.New("mysqlTLSName is required when mysqlTLSCert, mysqlTLSKey or mysqlTLSCA is set")
+	}
+	if (c.Cert == "") != (c.Key == "") {
+		return errors.New("mysqlTLSCert and mysqlTLSKey must be provided together")
+	}
+	if hasFiles && isBuiltinMySQLTLS(c.Name) {
+		return fmt.Errorf("mysqlTLSName %q is reserved by the MySQL driver", c.Name)
+	}
+	return nil
+}
+
+// isBuiltinMySQLTLS reports whether name is one of the driver's predefined
+// tls values, which cannot be registered.
+func isBuiltinMySQLTLS(name string) bool {
+	switch strings.ToLower(name) {
+	case "true", "false", "skip-verify", "preferred":
+		return true
+	}
+	return false
+}
+
+// tlsConfig builds a *tls.Config from the PEM files.
+func (c mysqlTLSConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CA != "" {
+		pem, err := os.ReadFile(c.CA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MySQL CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in MySQL CA file %s", c.CA)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MySQL client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// openMySQL registers the TLS configuration, if any, and opens a MySQL
+// handle whose DSN refers to it.
+func openMySQL(dsn string, opts mysqlTLSConfig) (*sql.DB, error) {
+	if opts.Name != "" {
+		if !isBuiltinMySQLTLS(opts.Name) {
+			tlsCfg, err := opts.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			if err := mysql.RegisterTLSConfig(opts.Name, tlsCfg); err != nil {
+				return nil, fmt.Errorf("failed to register MySQL TLS config: %w", err)
+			}
+		}
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MySQL DSN: %w", redactDSN(err, dsn))
+		}
+		cfg.TLSConfig = opts.Name
+		dsn = cfg.FormatDSN()
+	}
+	db, err := sql.Open(mysqlDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", redactDSN(err, dsn))
+	}
+	return db, nil
+}
+
+// poolConfig tunes a database/sql connection pool. Zero values keep the
+// database/sql defaults (unlimited open connections, no lifetime limit).
+type poolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+func pgPoolFromFlags() poolConfig {
+	return poolConfig{*pgMaxOpenConns, *pgMaxIdleConns, *pgConnMaxLifetime, *pgConnMaxIdleTime}
+}
+
+func mysqlPoolFromFlags() poolConfig {
+	return poolConfig{*mysqlMaxOpenConns, *mysqlMaxIdleConns, *mysqlConnMaxLifetime, *mysqlConnMaxIdleTime}
+}
+
+func (p poolConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	db.SetMaxIdleConns(p.MaxIdleConns)
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+}
+
+func (p poolConfig) validate(name string) error {
+	if p.MaxOpenConns < 0 || p.MaxIdleConns < 0 || p.ConnMaxLifetime < 0 || p.ConnMaxIdleTime < 0 {
+		return fmt.Errorf("%s pool settings must not be negative", name)
+	}
+	return nil
+}
+
+// mustPingDB verifies that db is reachable within -connectTimeout.
+func mustPingDB(ctx context.Context, db *sql.DB, name string) error {
+	if *connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *connectTimeout)
+		defer cancel()
+	}
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", name, transfer.ContextError(ctx, err))
+	}
+	return nil
+}
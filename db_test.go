@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// writeTestCerts writes a self-signed CA and a client certificate signed by
+// it to dir, returning the CA, certificate and key paths.
+func writeTestCerts(t *testing.T, dir string) (caPath, certPath, keyPath string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "oula test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "oula-transfer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTmpl, caTmpl, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	return write("ca.pem", "CERTIFICATE", caDER),
+		write("client.pem", "CERTIFICATE", clientDER),
+		write("client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func TestMySQLTLSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     mysqlTLSConfig
+		wantErr bool
+	}{
+		{"empty", mysqlTLSConfig{}, false},
+		{"builtin name only", mysqlTLSConfig{Name: "skip-verify"}, false},
+		{"custom with CA", mysqlTLSConfig{Name: "oula", CA: "ca.pem"}, false},
+		{"custom with client cert", mysqlTLSConfig{Name: "oula", Cert: "c.pem", Key: "k.pem"}, false},
+		{"files without name", mysqlTLSConfig{CA: "ca.pem"}, true},
+		{"cert without key", mysqlTLSConfig{Name: "oula", Cert: "c.pem"}, true},
+		{"key without cert", mysqlTLSConfig{Name: "oula", Key: "k.pem"}, true},
+		{"files with builtin name", mysqlTLSConfig{Name: "true", CA: "ca.pem"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenMySQLRegistersTLS(t *testing.T) {
+	caPath, certPath, keyPath := writeTestCerts(t, t.TempDir())
+	opts := mysqlTLSConfig{Name: "oula-test", Cert: certPath, Key: keyPath, CA: caPath}
+	db, err := openMySQL("oula:secret@tcp(mysql.internal:3306)/oula", opts)
+	if err != nil {
+		t.Fatalf("openMySQL: %v", err)
+	}
+	db.Close()
+	defer mysql.DeregisterTLSConfig(opts.Name)
+
+	// ParseDSN only accepts a tls value that names a registered config.
+	cfg, err := mysql.ParseDSN("oula:secret@tcp(mysql.internal:3306)/oula?tls=" + opts.Name)
+	if err != nil {
+		t.Fatalf("TLS config %q is not registered: %v", opts.Name, err)
+	}
+	if cfg.TLS == nil || cfg.TLS.RootCAs == nil {
+		t.Fatal("registered TLS config has no root CAs")
+	}
+	if len(cfg.TLS.Certificates) != 1 {
+		t.Errorf("registered TLS config has %d client certificates, want 1", len(cfg.TLS.Certificates))
+	}
+	if cfg.TLS.MinVersion < 0x0303 {
+		t.Errorf("MinVersion = %#x, want at least TLS 1.2", cfg.TLS.MinVersion)
+	}
+}
+
+func TestOpenMySQLInvalidTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		opts mysqlTLSConfig
+	}{
+		{"missing CA file", mysqlTLSConfig{Name: "oula-missing", CA: filepath.Join(dir, "missing.pem")}},
+		{"CA without certificates", mysqlTLSConfig{Name: "oula-empty", CA: notPEM}},
+		{"missing client key", mysqlTLSConfig{Name: "oula-nokey", Cert: notPEM, Key: filepath.Join(dir, "missing.pem")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if db, err := openMySQL("oula@tcp(localhost:3306)/oula", tt.opts); err == nil {
+				db.Close()
+				t.Fatal("openMySQL succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"oula-transfer/internal/transfer"
+)
+
+// DerivedMetric is computed from other metrics of the same run instead of
+// being queried from PostgreSQL. Its value is rounded to Scale decimal
+// places and stored in the value column of MySQLTable.
+type DerivedMetric struct {
+	Name               string
+	Formula            string
+	MySQLTable         string
+	Scale              int
+	ZeroOnDivideByZero bool
+
+	expr formulaExpr
+}
+
+const (
+	defaultDerivedScale = 2
+	maxDerivedScale     = 8
+)
+
+var errDivisionByZero = errors.New("division by zero")
+
+// scale returns the decimal places of d, applying the default.
+func (d DerivedMetric) scale() int {
+	if d.Scale == 0 {
+		return defaultDerivedScale
+	}
+	return d.Scale
+}
+
+// splitDerivedMetrics separates the entries that have a formula from the
+// queried metrics and parses their formulas. Every name a formula refers to
+// must be one of the queried metrics.
+func splitDerivedMetrics(metrics []MetricQuery) ([]MetricQuery, []DerivedMetric, error) {
+	var base []MetricQuery
+	var derived []DerivedMetric
+	for _, m := range metrics {
+		if m.Formula == "" {
+			base = append(base, m)
+			continue
+		}
+		expr, err := parseFormula(m.Formula)
+		if err != nil {
+			return nil, nil, fmt.Errorf("metric %s: invalid formula: %w", m.Name, err)
+		}
+		derived = append(derived, DerivedMetric{
+			Name:               m.Name,
+			Formula:            m.Formula,
+			MySQLTable:         m.MySQLTable,
+			Scale:              m.Scale,
+			ZeroOnDivideByZero: m.ZeroOnDivideByZero,
+			expr:               expr,
+		})
+	}
+
+	known := make(map[string]bool, len(base))
+	for _, m := range base {
+		known[m.Name] = true
+	}
+	for _, d := range derived {
+		for _, name := range d.expr.names(nil) {
+			if !known[name] {
+				return nil, nil, fmt.Errorf("metric %s: formula refers to unknown metric %s", d.Name, name)
+			}
+		}
+	}
+	return base, derived, nil
+}
+
+// evaluate computes d from the collected metric values.
+func (d DerivedMetric) evaluate(values map[string]float64) (float64, error) {
+	v, err := d.expr.eval(values)
+	if errors.Is(err, errDivisionByZero) && d.ZeroOnDivideByZero {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("formula %q evaluated to %v", d.Formula, v)
+	}
+	p := math.Pow10(d.scale())
+	return math.Round(v*p) / p, nil
+}
+
+// computeDerived evaluates every derived metric from results. A derived
+// metric whose inputs are missing or that cannot be evaluated is reported as
+// an error and skipped.
+func computeDerived(derived []DerivedMetric, results []transfer.Result) ([]transfer.Result, []error) {
+	values := make(map[string]float64, len(results))
+	for _, r := range results {
+		switch v := r.Stored().(type) {
+		case int:
+			values[r.Name] = float64(v)
+		case float64:
+			values[r.Name] = v
+		}
+	}
+	var out []transfer.Result
+	var errs []error
+	for _, d := range derived {
+		v, err := d.evaluate(values)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.Name, err))
+			continue
+		}
+		out = append(out, transfer.Result{Name: d.Name, Table: metricTable(d.MySQLTable), Value: v})
+	}
+	return out, errs
+}
+
+// derivedWithInputs returns the derived metrics whose formulas only refer to
+// metrics in queries, logging the ones it drops.
+func derivedWithInputs(derived []DerivedMetric, queries []MetricQuery) []DerivedMetric {
+	known := make(map[string]bool, len(queries))
+	for _, m := range queries {
+		known[m.Name] = true
+	}
+	var out []DerivedMetric
+	for _, d := range derived {
+		missing := ""
+		for _, name := range d.expr.names(nil) {
+			if !known[name] {
+				missing = name
+				break
+			}
+		}
+		if missing != "" {
+			slog.Info(fmt.Sprintf("skipping metric [%s] (excluded by flag)", d.Name), "input", missing)
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// formulaExpr is a parsed formula: numbers, metric names, + - * / and
+// parentheses.
+type formulaExpr interface {
+	eval(values map[string]float64) (float64, error)
+	names(acc []string) []string
+}
+
+type numberExpr float64
+
+func (n numberExpr) eval(map[string]float64) (float64, error) { return float64(n), nil }
+func (n numberExpr) names(acc []string) []string              { return acc }
+
+type metricRef string
+
+func (m metricRef) eval(values map[string]float64) (float64, error) {
+	v, ok := values[string(m)]
+	if !ok {
+		return 0, fmt.Errorf("metric %s is not available", string(m))
+	}
+	return v, nil
+}
+func (m metricRef) names(acc []string) []string { return append(acc, string(m)) }
+
+type negExpr struct{ x formulaExpr }
+
+func (n negExpr) eval(values map[string]float64) (float64, error) {
+	v, err := n.x.eval(values)
+	return -v, err
+}
+func (n negExpr) names(acc []string) []string { return n.x.names(acc) }
+
+type binaryExpr struct {
+	op   byte
+	l, r formulaExpr
+}
+
+func (b binaryExpr) eval(values map[string]float64) (float64, error) {
+	l, err := b.l.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	r, err := b.r.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	default:
+		if r == 0 {
+			return 0, errDivisionByZero
+		}
+		return l / r, nil
+	}
+}
+func (b binaryExpr) names(acc []string) []string { return b.r.names(b.l.names(acc)) }
+
+// formulaParser is a recursive-descent parser over the tokens of a formula.
+type formulaParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseFormula(s string) (formulaExpr, error) {
+	tokens, err := tokenizeFormula(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &formulaParser{tokens: tokens}
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+func tokenizeFormula(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("+-*/()", c):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '.' || unicode.IsDigit(c) || c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(s) && (s[j] == '.' || s[j] == '_' || unicode.IsDigit(rune(s[j])) || unicode.IsLetter(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("formula is empty")
+	}
+	return tokens, nil
+}
+
+func (p *formulaParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// expr := term (("+" | "-") term)*
+func (p *formulaParser) expr() (formulaExpr, error) {
+	left, err := p.term()
+	for err == nil && (p.next() == "+" || p.next() == "-") {
+		op := p.next()[0]
+		p.pos++
+		var right formulaExpr
+		if right, err = p.term(); err == nil {
+			left = binaryExpr{op, left, right}
+		}
+	}
+	return left, err
+}
+
+// term := factor (("*" | "/") factor)*
+func (p *formulaParser) term() (formulaExpr, error) {
+	left, err := p.factor()
+	for err == nil && (p.next() == "*" || p.next() == "/") {
+		op := p.next()[0]
+		p.pos++
+		var right formulaExpr
+		if right, err = p.factor(); err == nil {
+			left = binaryExpr{op, left, right}
+		}
+	}
+	return left, err
+}
+
+// factor := number | metric | "-" factor | "(" expr ")"
+func (p *formulaParser) factor() (formulaExpr, error) {
+	tok := p.next()
+	p.pos++
+	switch {
+	case tok == "":
+		return nil, errors.New("unexpected end of formula")
+	case tok == "-":
+		x, err := p.factor()
+		return negExpr{x}, err
+	case tok == "(":
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return x, nil
+	case tok[0] == '.' || unicode.IsDigit(rune(tok[0])):
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok)
+		}
+		return numberExpr(v), nil
+	case tok[0] == '_' || unicode.IsLetter(rune(tok[0])):
+		return metricRef(tok), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+}
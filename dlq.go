@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "modernc.org/sqlite"
+	"oula-transfer/internal/transfer"
+)
+
+// The dead-letter queue is a local SQLite file holding metric rows that
+// could not be written to MySQL, so they survive until -replayDLQ delivers
+// them.
+const dlqSchema = `CREATE TABLE IF NOT EXISTS dead_letters (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	table_name TEXT NOT NULL,
+	column_name TEXT NOT NULL,
+	date TEXT NOT NULL,
+	value TEXT NOT NULL,
+	error TEXT NOT NULL,
+	attempted_at TEXT NOT NULL
+)`
+
+// deadLetter is one metric row waiting in the dead-letter queue.
+type deadLetter struct {
+	id          int64
+	table       string
+	column      string
+	date        string
+	value       string
+	err         string
+	attemptedAt string
+}
+
+func openDLQ(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter queue %s: %w", path, err)
+	}
+	// SQLite allows a single writer; one connection avoids SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(dlqSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise dead-letter queue %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// writeDeadLetters stores results that failed to insert into MySQL with the
+// error that caused it.
+func writeDeadLetters(ctx context.Context, path, date string, results []transfer.Result, insertErr error) error {
+	db, err := openDLQ(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, r := range results {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO dead_letters (table_name, column_name, date, value, error, attempted_at) VALUES (?, ?, ?, ?, ?, ?)",
+			r.Table, r.Column(), date, fmt.Sprint(r.Stored()), insertErr.Error(), now); err != nil {
+			return fmt.Errorf("failed to write dead letter for %s: %w", r.Table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.Warn("Wrote failed inserts to the dead-letter queue", "path", path, "date", date, "rows", len(results))
+	return nil
+}
+
+func readDeadLetters(ctx context.Context, db *sql.DB) ([]deadLetter, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, table_name, column_name, date, value, error, attempted_at FROM dead_letters ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var letters []deadLetter
+	for rows.Next() {
+		var d deadLetter
+		if err := rows.Scan(&d.id, &d.table, &d.column, &d.date, &d.value, &d.err, &d.attemptedAt); err != nil {
+			return nil, err
+		}
+		letters = append(letters, d)
+	}
+	return letters, rows.Err()
+}
+
+// replayDLQ retries every dead letter against all MySQL destinations and
+// removes the ones that were delivered. Rows are upserted, since a
+// destination that already has the row from the original run must not make
+// the replay fail.
+func replayDLQ(ctx context.Context, path string) error {
+	dlq, err := openDLQ(path)
+	if err != nil {
+		return err
+	}
+	defer dlq.Close()
+
+	letters, err := readDeadLetters(ctx, dlq)
+	if err != nil {
+		return fmt.Errorf("failed to read dead-letter queue: %w", err)
+	}
+	if len(letters) == 0 {
+		slog.Info("Dead-letter queue is empty", "path", path)
+		return nil
+	}
+
+	var dbs []*sql.DB
+	for _, dsn := range mysqlTargets() {
+		db, err := openMySQL(dsn, mysqlTLSFromFlags())
+		if err != nil {
+			return redactDSN(err, dsn)
+		}
+		defer db.Close()
+		if err := mustPingDB(ctx, db, "MySQL"); err != nil {
+			return redactDSN(err, dsn)
+		}
+		dbs = append(dbs, db)
+	}
+
+	var errs []error
+	delivered := 0
+	w := transfer.Writer{Upsert: true, Timeout: *queryTimeout}
+	for _, d := range letters {
+		var failed error
+		for _, db := range dbs {
+			if err := w.InsertRow(ctx, db, d.table, d.column, d.date, d.value); err != nil {
+				failed = err
+				break
+			}
+		}
+		if failed != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", d.table, d.date, redact(failed)))
+			continue
+		}
+		if _, err := dlq.ExecContext(ctx, "DELETE FROM dead_letters WHERE id = ?", d.id); err != nil {
+			return fmt.Errorf("failed to remove delivered dead letter %d: %w", d.id, err)
+		}
+		delivered++
+	}
+	slog.Info("Dead-letter queue replayed", "path", path, "delivered", delivered, "remaining", len(letters)-delivered)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+// validateQueries runs a plain EXPLAIN of every query, with yesterday as
+// the date, so syntax errors and missing tables or columns are reported
+// without reading any data.
+func validateQueries(ctx context.Context, db *sql.DB, queries []MetricQuery) []error {
+	forDate := time.Now().AddDate(0, 0, -1)
+	var errs []error
+	for _, m := range queries {
+		query := renderDate(renderQuery(m.Query, *pgSchema), forDate)
+		qctx, cancel := withQueryTimeout(ctx)
+		rows, err := db.QueryContext(qctx, "EXPLAIN "+query, queryArgs(query, forDate)...)
+		if err == nil {
+			// The plan itself is not needed; draining the rows surfaces
+			// errors reported after the first row.
+			for rows.Next() {
+			}
+			err = rows.Err()
+			rows.Close()
+		}
+		err = transfer.ContextError(qctx, err)
+		cancel()
+		if err != nil {
+			slog.Error("Query check failed", "metric_name", m.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+			continue
+		}
+		slog.Info("Query check passed", "metric_name", m.Name)
+	}
+	return errs
+}
+
+// runCheckQueries validates the metric queries against every PostgreSQL
+// source, or each tenant's queries against its source.
+func runCheckQueries(ctx context.Context) error {
+	var errs []error
+	for _, target := range transferTargets() {
+		for _, dsn := range target.pgDsns {
+			db, err := openPostgres(dsn, pgTLSFromFlags())
+			if err == nil {
+				err = mustPingDB(ctx, db, "PostgreSQL")
+				if err == nil {
+					err = errors.Join(validateQueries(ctx, db, target.queries)...)
+				}
+				db.Close()
+			}
+			if err != nil {
+				errs = append(errs, target.wrap(redactDSN(err, dsn)))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// explainQuery runs EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) for query and
+// returns the plan as PostgreSQL printed it, which pev2 and similar tools
+// read as is. ANALYZE executes the query, so it costs as much as the query
+// itself.
+func explainQuery(ctx context.Context, db *sql.DB, query string, args ...any) (json.RawMessage, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var plan []byte
+	if err := db.QueryRowContext(ctx, "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) "+query, args...).Scan(&plan); err != nil {
+		return nil, fmt.Errorf("failed to explain query: %w", transfer.ContextError(ctx, err))
+	}
+	return json.RawMessage(plan), nil
+}
+
+// logQueryPlans logs the plan of query on every source for -explain.
+// Explaining is diagnostic only: a failure is logged and the metric is
+// still queried.
+func logQueryPlans(ctx context.Context, dbs []*sql.DB, name, query string, args ...any) {
+	for i, db := range dbs {
+		plan, err := explainQuery(ctx, db, query, args...)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to explain metric query", "metric_name", name, "source", i+1, "error", redact(err))
+			continue
+		}
+		// The JSON handler embeds the plan as an object; the text handler
+		// would print a RawMessage as bytes.
+		var attr slog.Attr
+		if *logFormat == "json" {
+			attr = slog.Any("plan", plan)
+		} else {
+			attr = slog.String("plan", string(plan))
+		}
+		slog.InfoContext(ctx, "Query plan", "metric_name", name, "source", i+1, attr)
+	}
+}
+
+// validateQuerySyntax has PostgreSQL parse and plan query with PREPARE, then
+// drops the prepared statement again; nothing is executed. A query that
+// binds the transfer date as $1 is prepared with a date parameter.
+func validateQuerySyntax(ctx context.Context, db *sql.DB, query string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return transfer.ContextError(ctx, err)
+	}
+	defer conn.Close()
+
+	params := ""
+	if dateParam.MatchString(query) {
+		params = "(date)"
+	}
+	if _, err := conn.ExecContext(ctx, "PREPARE oula_syntax_check"+params+" AS "+query); err != nil {
+		return transfer.ContextError(ctx, err)
+	}
+	_, err = conn.ExecContext(ctx, "DEALLOCATE oula_syntax_check")
+	return transfer.ContextError(ctx, err)
+}
+
+// checkQuerySyntaxAtStartup prepares the metric queries on every PostgreSQL
+// source, or on every selected tenant's source with that tenant's metrics,
+// so a broken query fails the start instead of the next scheduled run.
+func checkQuerySyntaxAtStartup(ctx context.Context) error {
+	var errs []error
+	for _, target := range transferTargets() {
+		for _, dsn := range target.pgDsns {
+			errs = append(errs, target.wrap(checkSourceQuerySyntax(ctx, dsn, target.queries)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkSourceQuerySyntax prepares queries on the source at dsn. An
+// unreachable source only skips the check; the transfer reports that on
+// its own.
+func checkSourceQuerySyntax(ctx context.Context, dsn string, queries []MetricQuery) error {
+	db, err := openPostgres(dsn, pgTLSFromFlags())
+	if err != nil {
+		return redactDSN(err, dsn)
+	}
+	defer db.Close()
+	if err := mustPingDB(ctx, db, "PostgreSQL"); err != nil {
+		slog.Warn("Skipping the query syntax check", "error", redactDSN(err, dsn))
+		return nil
+	}
+	var errs []error
+	for _, m := range queries {
+		query := renderDate(renderQuery(m.Query, *pgSchema), time.Now())
+		if err := validateQuerySyntax(ctx, db, query); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+		}
+	}
+	return redactDSN(errors.Join(errs...), dsn)
+}
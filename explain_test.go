@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectPrepare sets up the PREPARE and DEALLOCATE of one syntax check,
+// failing the PREPARE with fail when it is set.
+func expectPrepare(mock sqlmock.Sqlmock, query string, fail error) {
+	prepare := mock.ExpectExec("PREPARE oula_syntax_check(date) AS " + query)
+	if fail != nil {
+		prepare.WillReturnError(fail)
+		return
+	}
+	prepare.WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DEALLOCATE oula_syntax_check").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestCheckQuerySyntaxAtStartup(t *testing.T) {
+	syntaxErr := errors.New(`syntax error at or near "FORM"`)
+	tests := []struct {
+		name    string
+		tenants func(first, second string) []Tenant
+		// second is what the second source's metric_b check fails with;
+		// tenant b only runs metric_a, which fails the same way.
+		second  error
+		wantErr string
+	}{
+		{name: "sources"},
+		{name: "broken second source", second: syntaxErr, wantErr: "metric_b: " + syntaxErr.Error()},
+		{
+			name: "tenants",
+			tenants: func(first, second string) []Tenant {
+				return []Tenant{{Name: "a", PgDsn: first}, {Name: "b", PgDsn: second, Metrics: []string{"metric_a"}}}
+			},
+		},
+		{
+			name: "broken tenant",
+			tenants: func(first, second string) []Tenant {
+				return []Tenant{{Name: "a", PgDsn: first}, {Name: "b", PgDsn: second, Metrics: []string{"metric_a"}}}
+			},
+			second:  syntaxErr,
+			wantErr: "tenant b: metric_a: " + syntaxErr.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldDriver, oldSources, oldTenants := pgDriver, pgDsnList, tenants
+			t.Cleanup(func() { pgDriver, pgDsnList, tenants = oldDriver, oldSources, oldTenants })
+			pgDriver = "sqlmock"
+			useMetrics(t, mockMetrics, nil)
+
+			first, second := t.Name()+"/pg1", t.Name()+"/pg2"
+			firstDB, firstMock := newMockDSN(t, first)
+			secondDB, secondMock := newMockDSN(t, second)
+			t.Cleanup(func() {
+				firstDB.Close()
+				secondDB.Close()
+			})
+			expectPrepare(firstMock, mockQueryA, nil)
+			expectPrepare(firstMock, mockQueryB, nil)
+			if tt.tenants != nil {
+				tenants, pgDsnList = tt.tenants(first, second), nil
+				expectPrepare(secondMock, mockQueryA, tt.second)
+			} else {
+				tenants, pgDsnList = nil, dsnList{first, second}
+				expectPrepare(secondMock, mockQueryA, nil)
+				expectPrepare(secondMock, mockQueryB, tt.second)
+			}
+			firstMock.ExpectClose()
+			secondMock.ExpectClose()
+
+			err := checkQuerySyntaxAtStartup(context.Background())
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("checkQuerySyntaxAtStartup() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("checkQuerySyntaxAtStartup() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			for _, mock := range []sqlmock.Sqlmock{firstMock, secondMock} {
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateQuerySyntaxCancelled(t *testing.T) {
+	db, mock := newMockDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := validateQuerySyntax(ctx, db, mockQueryA); !errors.Is(err, context.Canceled) {
+		t.Fatalf("validateQuerySyntax() = %v, want context.Canceled", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
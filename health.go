@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const readinessTimeout = 5 * time.Second
+
+// newHealthMux serves the Kubernetes liveness and readiness probes, the last
+// transfer status and the manual transfer trigger.
+func newHealthMux(ctx context.Context) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("/transfer", newTransferHandler(ctx))
+	mux.HandleFunc("/transfer/", newTransferHandler(ctx))
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		for _, target := range transferTargets() {
+			// A simulated transfer never connects to PostgreSQL.
+			if !*simulate {
+				for _, dsn := range target.pgDsns {
+					if err := pingPostgres(ctx, dsn); err != nil {
+						err = target.wrap(err)
+						slog.Warn("Readiness check failed", "database", "postgres", "error", err)
+						http.Error(w, "postgres: "+err.Error(), http.StatusServiceUnavailable)
+						return
+					}
+				}
+			}
+			for _, dsn := range target.mysqlDsns {
+				if err := pingMySQL(ctx, dsn); err != nil {
+					err = target.wrap(err)
+					slog.Warn("Readiness check failed", "database", "mysql", "error", err)
+					http.Error(w, "mysql: "+err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// pingPostgres opens a fresh PostgreSQL connection with the TLS flags
+// applied, pings it and closes it again, so the probe never shares state
+// with a running transfer.
+func pingPostgres(ctx context.Context, dsn string) error {
+	db, err := openPostgres(dsn, pgTLSFromFlags())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return redactDSN(mustPingDB(ctx, db, "PostgreSQL"), dsn)
+}
+
+// pingMySQL is pingPostgres for MySQL, with the TLS flags applied.
+func pingMySQL(ctx context.Context, dsn string) error {
+	db, err := openMySQL(dsn, mysqlTLSFromFlags())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return redactDSN(mustPingDB(ctx, db, "MySQL"), dsn)
+}
+
+// startHealthServer serves the health endpoints on addr. An empty addr
+// disables it.
+func startHealthServer(ctx context.Context, addr string) {
+	if addr == "" {
+		return
+	}
+	go serveHTTP(ctx, &http.Server{Addr: addr, Handler: newHealthMux(ctx), ReadHeaderTimeout: 5 * time.Second}, "health")
+}
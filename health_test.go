@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name     string
+		simulate bool
+		// tenants lists the tenant names; each gets its own mock
+		// databases, unless it is "broken", whose DSNs are not registered.
+		tenants  []string
+		wantCode int
+		wantBody string
+	}{
+		{name: "flags", wantCode: http.StatusOK},
+		{name: "simulate skips PostgreSQL", simulate: true, wantCode: http.StatusOK},
+		{name: "tenants", tenants: []string{"a", "b"}, wantCode: http.StatusOK},
+		{name: "broken tenant", tenants: []string{"a", "broken"}, wantCode: http.StatusServiceUnavailable, wantBody: "tenant broken: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldPg, oldMySQL, oldTenants := pgDriver, mysqlDriver, tenants
+			pgDriver, mysqlDriver = "sqlmock", "sqlmock"
+			t.Cleanup(func() { pgDriver, mysqlDriver, tenants = oldPg, oldMySQL, oldTenants })
+			mockDSN := func(name string) string {
+				dsn := t.Name() + "/" + name
+				db, _ := newMockDSN(t, dsn)
+				t.Cleanup(func() { db.Close() })
+				return dsn
+			}
+
+			tenants = nil
+			for _, name := range tt.tenants {
+				tenant := Tenant{Name: name, PgDsn: t.Name() + "/unregistered", MysqlDsn: t.Name() + "/unregistered"}
+				if name != "broken" {
+					tenant.PgDsn, tenant.MysqlDsn = mockDSN(name+"/pg"), mockDSN(name+"/mysql")
+				}
+				tenants = append(tenants, tenant)
+			}
+			if tt.tenants == nil {
+				// With -simulate the PostgreSQL DSN is not even needed.
+				if !tt.simulate {
+					setFlag(t, "pgDsn", mockDSN("pg"))
+				}
+				setFlag(t, "mysqlDsn", mockDSN("mysql"))
+			}
+			if tt.simulate {
+				setFlag(t, "simulate", "true")
+			}
+
+			rec := httptest.NewRecorder()
+			newHealthMux(context.Background()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
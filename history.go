@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+// Statuses recorded in the transfer_runs table.
+const (
+	runStatusRunning = "running"
+	runStatusSuccess = "success"
+	runStatusPartial = "partial"
+	runStatusFailure = "failure"
+)
+
+// insertTransferRun records the start of a transfer for date and returns the
+// id of the new transfer_runs row.
+func insertTransferRun(ctx context.Context, db *sql.DB, date string, startedAt time.Time) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx,
+		"INSERT INTO transfer_runs (transfer_date, started_at, status) VALUES (?, ?, ?)",
+		date, startedAt.UTC(), runStatusRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record transfer run: %w", transfer.ContextError(ctx, err))
+	}
+	return res.LastInsertId()
+}
+
+// updateTransferRun records the outcome of the transfer run with the given id.
+func updateTransferRun(ctx context.Context, db *sql.DB, id int64, res transfer.Summary, err error) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var msg sql.NullString
+	if err != nil {
+		msg = sql.NullString{String: err.Error(), Valid: true}
+	}
+	if _, execErr := db.ExecContext(ctx,
+		`UPDATE transfer_runs
+		SET completed_at = ?, status = ?, metrics_succeeded = ?, metrics_failed = ?, error_message = ?
+		WHERE id = ?`,
+		res.CompletedAt.UTC(), runStatus(res, err), res.MetricsSucceeded, res.MetricsFailed, msg, id); execErr != nil {
+		return fmt.Errorf("failed to update transfer run %d: %w", id, transfer.ContextError(ctx, execErr))
+	}
+	return nil
+}
+
+// transferHistory records the runs of a transfer in transfer_runs.
+type transferHistory struct{}
+
+func (transferHistory) Start(ctx context.Context, db *sql.DB, date string, startedAt time.Time) (int64, error) {
+	return insertTransferRun(ctx, db, date, startedAt)
+}
+
+func (transferHistory) Finish(ctx context.Context, db *sql.DB, id int64, res transfer.Summary, err error) error {
+	return updateTransferRun(ctx, db, id, res, err)
+}
+
+// runStatus classifies a finished run: partial when some but not all metrics
+// made it to MySQL.
+func runStatus(res transfer.Summary, err error) string {
+	switch {
+	case err == nil:
+		return runStatusSuccess
+	case res.MetricsSucceeded > 0:
+		return runStatusPartial
+	default:
+		return runStatusFailure
+	}
+}
+
+// transferRunRow is one row of transfer_runs as printed by -status. Times are
+// left as MySQL returns them, since the DSN need not set parseTime.
+type transferRunRow struct {
+	ID               int64   `json:"id"`
+	TransferDate     string  `json:"transfer_date"`
+	StartedAt        string  `json:"started_at"`
+	CompletedAt      *string `json:"completed_at"`
+	Status           string  `json:"status"`
+	MetricsSucceeded int     `json:"metrics_succeeded"`
+	MetricsFailed    int     `json:"metrics_failed"`
+	ErrorMessage     *string `json:"error_message"`
+}
+
+// lastTransferRun returns the most recently started run, or nil when the
+// table is empty.
+func lastTransferRun(ctx context.Context, db *sql.DB) (*transferRunRow, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var r transferRunRow
+	var completed, msg sql.NullString
+	err := db.QueryRowContext(ctx,
+		`SELECT id, transfer_date, started_at, completed_at, status, metrics_succeeded, metrics_failed, error_message
+		FROM transfer_runs ORDER BY started_at DESC, id DESC LIMIT 1`).
+		Scan(&r.ID, &r.TransferDate, &r.StartedAt, &completed, &r.Status, &r.MetricsSucceeded, &r.MetricsFailed, &msg)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transfer runs: %w", transfer.ContextError(ctx, err))
+	}
+	if completed.Valid {
+		r.CompletedAt = &completed.String
+	}
+	if msg.Valid {
+		r.ErrorMessage = &msg.String
+	}
+	return &r, nil
+}
+
+// printTransferRun writes r to w as indented JSON or as one "key: value"
+// line per field.
+func printTransferRun(w io.Writer, r *transferRunRow, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	completed, msg := "-", "-"
+	if r.CompletedAt != nil {
+		completed = *r.CompletedAt
+	}
+	if r.ErrorMessage != nil {
+		msg = *r.ErrorMessage
+	}
+	_, err := fmt.Fprintf(w, "run:       %d\ndate:      %s\nstatus:    %s\nstarted:   %s\ncompleted: %s\nsucceeded: %d\nfailed:    %d\nerror:     %s\n",
+		r.ID, r.TransferDate, r.Status, r.StartedAt, completed, r.MetricsSucceeded, r.MetricsFailed, msg)
+	return err
+}
+
+// startedAt parses r.StartedAt, which MySQL returns as "2006-01-02
+// 15:04:05" or, with parseTime in the DSN, as RFC 3339. Runs are recorded
+// in UTC.
+func (r *transferRunRow) startedAt() (time.Time, error) {
+	for _, layout := range []string{time.DateTime, time.RFC3339Nano} {
+		if t, err := time.ParseInLocation(layout, r.StartedAt, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid started_at %q", r.StartedAt)
+}
+
+// isStale reports whether r is still marked running more than after past
+// its start, which means the process died before recording the outcome.
+func (r *transferRunRow) isStale(now time.Time, after time.Duration) (bool, error) {
+	if r.Status != runStatusRunning || after <= 0 {
+		return false, nil
+	}
+	started, err := r.startedAt()
+	if err != nil {
+		return false, err
+	}
+	return now.Sub(started) > after, nil
+}
+
+// runStatusCommand prints the last transfer run from the first MySQL
+// destination and returns the process exit code: 0 when it succeeded or is
+// still running, 2 when it failed, was partial or has been running for
+// longer than -staleRunAfter, 1 when there is no run or the table cannot be
+// read.
+func runStatusCommand(ctx context.Context, asJSON bool) int {
+	targets := mysqlTargets()
+	if len(targets) == 0 {
+		slog.Error("-status needs a MySQL DSN")
+		return 1
+	}
+	db, err := openMySQL(targets[0], mysqlTLSFromFlags())
+	if err != nil {
+		slog.Error("Failed to open MySQL", "error", redactDSN(err, targets[0]))
+		return 1
+	}
+	defer db.Close()
+
+	run, err := lastTransferRun(ctx, db)
+	if err != nil {
+		slog.Error("Failed to read the last transfer run", "error", redactDSN(err, targets[0]))
+		return 1
+	}
+	if run == nil {
+		slog.Error("No transfer runs found")
+		return 1
+	}
+	if err := printTransferRun(os.Stdout, run, asJSON); err != nil {
+		slog.Error("Failed to print the last transfer run", "error", err)
+		return 1
+	}
+	if run.Status == runStatusFailure || run.Status == runStatusPartial {
+		return 2
+	}
+	stale, err := run.isStale(time.Now(), *staleRunAfter)
+	if err != nil {
+		slog.Error("Failed to check the last transfer run", "error", err)
+		return 1
+	}
+	if stale {
+		slog.Error("Last transfer run never finished", "id", run.ID, "started_at", run.StartedAt, "stale_after", staleRunAfter.String())
+		return 2
+	}
+	return 0
+}
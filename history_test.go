@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRunStatusCommand(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name       string
+		status     string
+		startedAt  string
+		staleAfter string
+		empty      bool
+		want       int
+	}{
+		{name: "success", status: runStatusSuccess, startedAt: now.Add(-10 * time.Hour).Format(time.DateTime), want: 0},
+		{name: "failure", status: runStatusFailure, startedAt: now.Format(time.DateTime), want: 2},
+		{name: "partial", status: runStatusPartial, startedAt: now.Format(time.DateTime), want: 2},
+		{name: "running", status: runStatusRunning, startedAt: now.Add(-time.Minute).Format(time.DateTime), want: 0},
+		{name: "stale", status: runStatusRunning, startedAt: now.Add(-7 * time.Hour).Format(time.DateTime), want: 2},
+		{name: "stale with parseTime", status: runStatusRunning, startedAt: now.Add(-7 * time.Hour).Format(time.RFC3339), want: 2},
+		{name: "stale check disabled", status: runStatusRunning, startedAt: now.Add(-7 * time.Hour).Format(time.DateTime), staleAfter: "0", want: 0},
+		{name: "shorter threshold", status: runStatusRunning, startedAt: now.Add(-time.Hour).Format(time.DateTime), staleAfter: "30m", want: 2},
+		{name: "invalid started_at", status: runStatusRunning, startedAt: "yesterday", want: 1},
+		{name: "no runs", empty: true, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldDriver := mysqlDriver
+			mysqlDriver = "sqlmock"
+			t.Cleanup(func() { mysqlDriver = oldDriver })
+			if tt.staleAfter != "" {
+				setFlag(t, "staleRunAfter", tt.staleAfter)
+			}
+
+			dsn := t.Name() + "/mysql"
+			db, mock, err := sqlmock.NewWithDSN(dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			setFlag(t, "mysqlDsn", dsn)
+
+			rows := sqlmock.NewRows([]string{"id", "transfer_date", "started_at", "completed_at", "status", "metrics_succeeded", "metrics_failed", "error_message"})
+			if !tt.empty {
+				rows.AddRow(7, "2024-01-15", tt.startedAt, nil, tt.status, 0, 0, nil)
+			}
+			mock.ExpectQuery("FROM transfer_runs ORDER BY started_at DESC").WillReturnRows(rows)
+
+			if got := runStatusCommand(context.Background(), false); got != tt.want {
+				t.Errorf("runStatusCommand() = %d, want %d", got, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"oula-transfer/internal/transfer"
+)
+
+// influxMeasurement is the measurement every metric row is written to.
+const influxMeasurement = "oula_metrics"
+
+// influxConfig says where writeToInflux stores each run's metrics.
+type influxConfig struct {
+	URL    string
+	Token  string
+	Org    string
+	Bucket string
+}
+
+func influxConfigFromFlags() influxConfig {
+	return influxConfig{URL: *influxURL, Token: *influxToken, Org: *influxOrg, Bucket: *influxBucket}
+}
+
+// metricProject returns the project a metric was expanded for, or "" for
+// metrics that are not per project.
+func metricProject(name string) string {
+	for _, m := range metricQueries {
+		if m.Name == name {
+			return m.Project
+		}
+	}
+	return ""
+}
+
+// influxPoint turns a row into a point timestamped at midnight UTC of the
+// transfer date. Int metrics use the count field, the others value.
+func influxPoint(row metricRow) (*write.Point, error) {
+	at, err := time.Parse("2006-01-02", row.Date)
+	if err != nil {
+		return nil, fmt.Errorf("metric %s: invalid date %q: %w", row.MetricName, row.Date, err)
+	}
+	field := "value"
+	if _, ok := row.Count.(int); ok {
+		field = "count"
+	}
+	p := influxdb2.NewPointWithMeasurement(influxMeasurement).
+		AddTag("metric_name", row.MetricName).
+		AddField(field, row.Count).
+		SetTime(at)
+	if project := metricProject(row.MetricName); project != "" {
+		p.AddTag("project", project)
+	}
+	return p, nil
+}
+
+// writeToInflux writes rows to cfg.Bucket in one blocking batch.
+func writeToInflux(ctx context.Context, cfg influxConfig, rows []metricRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	points := make([]*write.Point, 0, len(rows))
+	for _, row := range rows {
+		p, err := influxPoint(row)
+		if err != nil {
+			return err
+		}
+		points = append(points, p)
+	}
+
+	client := influxdb2.NewClient(cfg.URL, cfg.Token)
+	defer client.Close()
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if err := client.WriteAPIBlocking(cfg.Org, cfg.Bucket).WritePoint(ctx, points...); err != nil {
+		return fmt.Errorf("failed to write metrics to InfluxDB bucket %s: %w", cfg.Bucket, transfer.ContextError(ctx, err))
+	}
+	slog.Info("Wrote metrics to InfluxDB", "bucket", cfg.Bucket, "rows", len(rows))
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// influxWriteAPI records the requests sent to a mock /api/v2/write.
+type influxWriteAPI struct {
+	status int
+	query  map[string]string
+	auth   string
+	lines  []string
+}
+
+func (a *influxWriteAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/api/v2/write" {
+		http.NotFound(w, r)
+		return
+	}
+	a.query = map[string]string{}
+	for k := range r.URL.Query() {
+		a.query[k] = r.URL.Query().Get(k)
+	}
+	a.auth = r.Header.Get("Authorization")
+	body, _ := io.ReadAll(r.Body)
+	a.lines = append(a.lines, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+	if a.status != http.StatusNoContent {
+		http.Error(w, `{"code":"invalid","message":"bucket not found"}`, a.status)
+		return
+	}
+	w.WriteHeader(a.status)
+}
+
+func TestWriteToInflux(t *testing.T) {
+	useMetrics(t, []MetricQuery{
+		{Name: "active_machines_count_aleo", MySQLTable: "active_machines_count_aleo", Project: "ALEO"},
+		{Name: "avg_machines_per_user", MySQLTable: "avg_machines_per_user"},
+	}, nil)
+	api := &influxWriteAPI{status: http.StatusNoContent}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	cfg := influxConfig{URL: srv.URL, Token: "s3cret", Org: "oula", Bucket: "metrics"}
+	rows := []metricRow{
+		{Date: "2024-01-15", MetricName: "active_machines_count_aleo", Count: 42},
+		{Date: "2024-01-15", MetricName: "avg_machines_per_user", Count: 1.5},
+	}
+	if err := writeToInflux(context.Background(), cfg, rows); err != nil {
+		t.Fatalf("writeToInflux: %v", err)
+	}
+
+	if api.query["org"] != "oula" || api.query["bucket"] != "metrics" {
+		t.Errorf("query = %v, want org=oula and bucket=metrics", api.query)
+	}
+	if api.auth != "Token s3cret" {
+		t.Errorf("Authorization = %q, want %q", api.auth, "Token s3cret")
+	}
+	// 2024-01-15T00:00:00Z in nanoseconds.
+	want := []string{
+		"oula_metrics,metric_name=active_machines_count_aleo,project=ALEO count=42i 1705276800000000000",
+		"oula_metrics,metric_name=avg_machines_per_user value=1.5 1705276800000000000",
+	}
+	sort.Strings(api.lines)
+	if strings.Join(api.lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("line protocol:\n%s\nwant:\n%s", strings.Join(api.lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWriteToInfluxErrors(t *testing.T) {
+	api := &influxWriteAPI{status: http.StatusNotFound}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	cfg := influxConfig{URL: srv.URL, Org: "oula", Bucket: "missing"}
+
+	if err := writeToInflux(context.Background(), cfg, []metricRow{{Date: "2024-01-15", MetricName: "a", Count: 1}}); err == nil {
+		t.Error("writeToInflux succeeded against a failing write API")
+	}
+	if err := writeToInflux(context.Background(), cfg, []metricRow{{Date: "15/01/2024", MetricName: "a", Count: 1}}); err == nil {
+		t.Error("writeToInflux accepted an invalid date")
+	}
+	if err := writeToInflux(context.Background(), influxConfig{URL: "http://127.0.0.1:0"}, nil); err != nil {
+		t.Errorf("writeToInflux with no rows: %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Summary summarises one transfer run.
+type Summary struct {
+	Date             string
+	MetricsAttempted int
+	MetricsSucceeded int
+	MetricsFailed    int
+	StartedAt        time.Time
+	CompletedAt      time.Time
+	Duration         time.Duration
+	FailedMetrics    []string
+	// Counts holds the value transferred for each successful metric.
+	Counts map[string]int
+}
+
+// LogValue renders the summary as a single group of structured attributes.
+func (s Summary) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("date", s.Date),
+		slog.Int("metrics_attempted", s.MetricsAttempted),
+		slog.Int("metrics_succeeded", s.MetricsSucceeded),
+		slog.Int("metrics_failed", s.MetricsFailed),
+		slog.Any("failed_metrics", s.FailedMetrics),
+		slog.Time("started_at", s.StartedAt),
+		slog.Time("completed_at", s.CompletedAt),
+		slog.Int64("duration_ms", s.Duration.Milliseconds()),
+	)
+}
+
+// Query is a metric a Job collects from its PostgreSQL sources.
+type Query struct {
+	Name string
+	// Collect queries the metric from sources. It runs on the worker pool,
+	// so anything it shares with other metrics must be guarded.
+	Collect func(ctx context.Context, sources []*sql.DB) (Result, error)
+}
+
+// Fallback keeps results MySQL could not take until a later run reaches it
+// again.
+type Fallback interface {
+	// Flush moves the rows buffered by earlier runs into db.
+	Flush(ctx context.Context, db *sql.DB) error
+	// Buffer keeps results that cause stopped from reaching MySQL. The
+	// results count as written when it returns nil.
+	Buffer(ctx context.Context, date string, results []Result, cause error) error
+}
+
+// History records every run in the first destination. It is best effort:
+// its errors are logged and never fail the run.
+type History interface {
+	Start(ctx context.Context, db *sql.DB, date string, startedAt time.Time) (id int64, err error)
+	Finish(ctx context.Context, db *sql.DB, id int64, s Summary, err error) error
+}
+
+// Job is one day's transfer from PostgreSQL sources to MySQL destinations.
+// The fields are the whole configuration; how a metric is queried and what
+// else happens to the results are left to the hooks.
+type Job struct {
+	// Sources are the PostgreSQL DSNs handed to every Query.
+	Sources []string
+	// Destinations are the MySQL DSNs every result is written to. The
+	// first one also holds the history and is given to Prepare and Finish.
+	Destinations []string
+	// OpenSource and OpenDestination open a connection pool for a DSN.
+	OpenSource      func(dsn string) (*sql.DB, error)
+	OpenDestination func(dsn string) (*sql.DB, error)
+	// Redact, when set, removes the credentials of dsns from err.
+	Redact func(err error, dsns ...string) error
+
+	// ConnectTimeout bounds the ping of each database; zero disables it.
+	ConnectTimeout time.Duration
+	// MaxDuration bounds the whole run. Unlike a shutdown, running out of
+	// it also cancels the database call in flight. Zero is no limit.
+	MaxDuration time.Duration
+
+	Queries []Query
+	// Derived names the metrics Prepare adds, so the summary counts them.
+	Derived []string
+	// Workers is how many metrics are collected at the same time.
+	Workers int
+	// DryRun logs the results instead of writing them.
+	DryRun bool
+	// Writer inserts the results into the destinations.
+	Writer Writer
+
+	// CheckSchema, when set, checks each destination before any query runs.
+	CheckSchema func(ctx context.Context, db *sql.DB) error
+	// Lock, when set, is taken on the first source for the run. A run that
+	// does not get it is skipped without an error.
+	Lock func(ctx context.Context, db *sql.DB) (unlock func(), acquired bool, err error)
+	// History, when set, records the run.
+	History History
+	// Fallback, when set, takes the results when MySQL fails or cannot be
+	// reached at all. It is not used in a dry run.
+	Fallback Fallback
+	// Prepare runs between the query and write phases; see Options.Prepare.
+	// db is the first reachable destination, or nil.
+	Prepare func(ctx context.Context, db *sql.DB, results []Result) ([]Result, []error)
+	// WriteFailed, when set, is told about results that neither the
+	// destinations nor the Fallback took.
+	WriteFailed func(ctx context.Context, date string, results []Result, err error)
+	// Export, when set, runs once the results are in MySQL, or straight
+	// away without destinations; see Options.Write.
+	Export func(ctx context.Context, results []Result) (warnings []error, err error)
+	// Finish, when set, runs after the write phase of a run that is not a
+	// dry run, with the results that were written.
+	Finish func(ctx context.Context, db *sql.DB, written []Result) []error
+}
+
+// Run transfers date. A failing metric is logged and skipped so the
+// remaining metrics still get transferred; all failures are returned joined
+// together. Once ctx is cancelled no further metrics are started, but the
+// metric in progress is allowed to finish so MySQL is not left mid-write.
+func (j Job) Run(ctx context.Context, date string) (s Summary, err error) {
+	s = Summary{Date: date, StartedAt: time.Now(), MetricsAttempted: len(j.Queries) + len(j.Derived)}
+	slog.InfoContext(ctx, "Starting data transfer", "date", date)
+
+	var succeeded []string
+	defer func() {
+		s.CompletedAt = time.Now()
+		s.Duration = s.CompletedAt.Sub(s.StartedAt)
+		s.MetricsSucceeded = len(succeeded)
+		s.MetricsFailed = s.MetricsAttempted - s.MetricsSucceeded
+		if s.MetricsFailed > 0 {
+			s.FailedMetrics = j.failedMetrics(succeeded)
+		}
+	}()
+
+	ctx, span := tracer.Start(ctx, "transferData", trace.WithAttributes(attribute.String("oula.date", date)))
+	defer func() { endSpan(span, err) }()
+
+	var deadline time.Time
+	if j.MaxDuration > 0 {
+		deadline = s.StartedAt.Add(j.MaxDuration)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	sources := make([]*sql.DB, 0, len(j.Sources))
+	for _, dsn := range j.Sources {
+		db, err := j.OpenSource(dsn)
+		if err != nil {
+			return s, j.redact(err, dsn)
+		}
+		defer db.Close()
+		sources = append(sources, db)
+	}
+	dests := make([]*sql.DB, 0, len(j.Destinations))
+	for _, dsn := range j.Destinations {
+		db, err := j.OpenDestination(dsn)
+		if err != nil {
+			return s, j.redact(err, dsn)
+		}
+		defer db.Close()
+		dests = append(dests, db)
+	}
+
+	// sql.Open is lazy; fail fast if either database is unreachable.
+	for i, db := range sources {
+		if err := j.ping(ctx, db, "PostgreSQL"); err != nil {
+			return s, j.redact(err, j.Sources[i])
+		}
+	}
+	fallback := j.Fallback
+	if j.DryRun {
+		fallback = nil
+	}
+	for i, db := range dests {
+		if err := j.ping(ctx, db, "MySQL"); err != nil {
+			if fallback == nil {
+				return s, j.redact(err, j.Destinations[i])
+			}
+			slog.WarnContext(ctx, "MySQL is unreachable, buffering rows in the fallback", "error", j.redact(err, j.Destinations[i]))
+			dests = nil
+			break
+		}
+	}
+	var first *sql.DB
+	if len(dests) > 0 {
+		first = dests[0]
+	}
+
+	// Rows buffered by earlier runs go out before today's, so a later
+	// flush can never overwrite newer values.
+	if fallback != nil && first != nil {
+		if err := fallback.Flush(ctx, first); err != nil {
+			slog.ErrorContext(ctx, "Failed to flush the fallback, rows stay buffered", "error", j.redact(err, j.Destinations[0]))
+		}
+	}
+
+	// Database calls are bounded by the query timeouts and MaxDuration but
+	// not by shutdown: the metric in progress is allowed to finish.
+	dbCtx := context.WithoutCancel(ctx)
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		dbCtx, cancel = context.WithDeadline(dbCtx, deadline)
+		defer cancel()
+	}
+
+	// Check the destination schema before spending time on the queries.
+	if j.CheckSchema != nil {
+		for i, db := range dests {
+			if err := j.CheckSchema(dbCtx, db); err != nil {
+				return s, j.redact(err, j.Destinations[i])
+			}
+		}
+	}
+
+	if j.Lock != nil && len(sources) > 0 {
+		unlock, acquired, err := j.Lock(dbCtx, sources[0])
+		if err != nil {
+			return s, j.redact(err, j.Sources[0])
+		}
+		if !acquired {
+			slog.WarnContext(ctx, "Another instance holds the advisory lock, skipping run", "date", date)
+			s.MetricsAttempted = 0
+			return s, nil
+		}
+		defer unlock()
+	}
+
+	if j.History != nil && !j.DryRun && first != nil {
+		if id, herr := j.History.Start(dbCtx, first, date, s.StartedAt); herr != nil {
+			slog.WarnContext(ctx, "Failed to record transfer run", "date", date, "error", j.redact(herr, j.Destinations[0]))
+		} else {
+			defer func() {
+				// Deferred calls run last-in first-out, so the summary
+				// defer above has not filled in s yet.
+				s.CompletedAt = time.Now()
+				s.MetricsSucceeded = len(succeeded)
+				s.MetricsFailed = s.MetricsAttempted - s.MetricsSucceeded
+				// The run may have failed by exhausting dbCtx's deadline;
+				// recording that must not be cut short by it.
+				if uerr := j.History.Finish(context.WithoutCancel(dbCtx), first, id, s, err); uerr != nil {
+					slog.WarnContext(ctx, "Failed to update transfer run", "date", date, "error", j.redact(uerr, j.Destinations[0]))
+				}
+			}()
+		}
+	}
+
+	metrics := make([]Metric, 0, len(j.Queries))
+	for _, q := range j.Queries {
+		q := q
+		metrics = append(metrics, Metric{Name: q.Name, Collect: func() (Result, error) {
+			return q.Collect(dbCtx, sources)
+		}})
+	}
+	opts := Options{
+		Workers: j.Workers,
+		DryRun:  j.DryRun,
+		Write: func(_ context.Context, results []Result) ([]error, error) {
+			return j.write(dbCtx, dests, fallback, date, results)
+		},
+	}
+	if j.Prepare != nil {
+		opts.Prepare = func(_ context.Context, results []Result) ([]Result, []error) {
+			return j.Prepare(dbCtx, first, results)
+		}
+	}
+	// All collected metrics for the day are written in one transaction per
+	// destination so downstream readers never see a partial day.
+	written, runErr := Run(ctx, date, metrics, opts)
+	var errs []error
+	if runErr != nil {
+		errs = append(errs, runErr)
+	}
+	for _, r := range written {
+		succeeded = append(succeeded, r.Name)
+	}
+	if !j.DryRun && len(written) > 0 {
+		s.Counts = make(map[string]int, len(written))
+		for _, r := range written {
+			if r.Value == nil {
+				s.Counts[r.Name] = r.Count
+			}
+		}
+	}
+	if !j.DryRun && j.Finish != nil {
+		errs = append(errs, j.Finish(dbCtx, first, written)...)
+	}
+
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		slog.ErrorContext(ctx, "Transfer exceeded its maximum duration", "date", date, "max_duration", j.MaxDuration.String())
+		errs = append(errs, fmt.Errorf("transfer exceeded its maximum duration of %s", j.MaxDuration))
+	}
+	return s, errors.Join(errs...)
+}
+
+// write stores results in dests, or in fallback when that fails or there
+// are none, then hands them to Export. The returned error fails every
+// result; the warnings are failed extra copies.
+func (j Job) write(ctx context.Context, dests []*sql.DB, fallback Fallback, date string, results []Result) ([]error, error) {
+	var err error
+	if len(dests) > 0 {
+		if insertErrs := j.Writer.InsertToAll(ctx, dests, date, results); len(insertErrs) > 0 {
+			err = j.redact(errors.Join(insertErrs...), j.Destinations...)
+			if fallback != nil {
+				err = fallback.Buffer(ctx, date, results, err)
+			}
+		}
+	} else if fallback != nil {
+		err = fallback.Buffer(ctx, date, results, errors.New("MySQL is unreachable"))
+	}
+	if err != nil {
+		if j.WriteFailed != nil {
+			j.WriteFailed(ctx, date, results, err)
+		}
+		return nil, err
+	}
+	if j.Export == nil {
+		return nil, nil
+	}
+	return j.Export(ctx, results)
+}
+
+// ping checks that db is reachable within ConnectTimeout.
+func (j Job) ping(ctx context.Context, db *sql.DB, name string) error {
+	if j.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.ConnectTimeout)
+		defer cancel()
+	}
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", name, ContextError(ctx, err))
+	}
+	return nil
+}
+
+func (j Job) redact(err error, dsns ...string) error {
+	if j.Redact == nil || err == nil {
+		return err
+	}
+	return j.Redact(err, dsns...)
+}
+
+// failedMetrics lists the queried and derived metrics that are not in
+// succeeded, in definition order.
+func (j Job) failedMetrics(succeeded []string) []string {
+	ok := make(map[string]bool, len(succeeded))
+	for _, name := range succeeded {
+		ok[name] = true
+	}
+	var failed []string
+	for _, q := range j.Queries {
+		if !ok[q.Name] {
+			failed = append(failed, q.Name)
+		}
+	}
+	for _, name := range j.Derived {
+		if !ok[name] {
+			failed = append(failed, name)
+		}
+	}
+	return failed
+}
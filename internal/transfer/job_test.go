@@ -0,0 +1,261 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// fakeHistory remembers the summary a run finished with.
+type fakeHistory struct {
+	started  bool
+	finished *Summary
+}
+
+func (h *fakeHistory) Start(context.Context, *sql.DB, string, time.Time) (int64, error) {
+	h.started = true
+	return 7, nil
+}
+
+func (h *fakeHistory) Finish(_ context.Context, _ *sql.DB, id int64, s Summary, _ error) error {
+	if id != 7 {
+		return errors.New("unknown run")
+	}
+	h.finished = &s
+	return nil
+}
+
+// fakeFallback buffers in memory; flushing always succeeds.
+type fakeFallback struct {
+	flushed  bool
+	buffered []Result
+}
+
+func (f *fakeFallback) Flush(context.Context, *sql.DB) error {
+	f.flushed = true
+	return nil
+}
+
+func (f *fakeFallback) Buffer(_ context.Context, _ string, results []Result, _ error) error {
+	f.buffered = append(f.buffered, results...)
+	return nil
+}
+
+func TestJobRun(t *testing.T) {
+	errQuery := errors.New("relation does not exist")
+	errInsert := errors.New("duplicate entry")
+	errPing := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		failA     bool
+		insertErr error
+		pingErr   error
+		fallback  bool
+		locked    bool
+		dryRun    bool
+		derived   []string
+
+		wantSucceeded   int
+		wantFailed      []string
+		wantCounts      map[string]int
+		wantErr         []error
+		wantBuffered    int
+		wantWriteFailed bool
+		wantHistory     bool
+	}{
+		{
+			name:          "written",
+			wantSucceeded: 2,
+			wantCounts:    map[string]int{"a": 3, "b": 5},
+			wantHistory:   true,
+		},
+		{
+			name:          "failing query is skipped",
+			failA:         true,
+			wantSucceeded: 1,
+			wantFailed:    []string{"a"},
+			wantCounts:    map[string]int{"b": 5},
+			wantErr:       []error{errQuery},
+			wantHistory:   true,
+		},
+		{
+			name:          "missing derived metric fails",
+			derived:       []string{"ratio"},
+			wantSucceeded: 2,
+			wantFailed:    []string{"ratio"},
+			wantCounts:    map[string]int{"a": 3, "b": 5},
+			wantHistory:   true,
+		},
+		{
+			name:            "insert failure",
+			insertErr:       errInsert,
+			wantFailed:      []string{"a", "b"},
+			wantErr:         []error{errInsert},
+			wantWriteFailed: true,
+			wantHistory:     true,
+		},
+		{
+			name:          "insert failure is buffered",
+			insertErr:     errInsert,
+			fallback:      true,
+			wantSucceeded: 2,
+			wantCounts:    map[string]int{"a": 3, "b": 5},
+			wantBuffered:  2,
+			wantHistory:   true,
+		},
+		{
+			name:       "unreachable destination",
+			pingErr:    errPing,
+			wantFailed: []string{"a", "b"},
+			wantErr:    []error{errPing},
+		},
+		{
+			name:          "unreachable destination is buffered",
+			pingErr:       errPing,
+			fallback:      true,
+			wantSucceeded: 2,
+			wantCounts:    map[string]int{"a": 3, "b": 5},
+			wantBuffered:  2,
+		},
+		{
+			name:   "lock held elsewhere",
+			locked: true,
+		},
+		{
+			name:          "dry run",
+			dryRun:        true,
+			wantSucceeded: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pg, pgMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			my, myMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true), sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pgMock.ExpectPing()
+			myMock.ExpectPing().WillReturnError(tt.pingErr)
+			if tt.pingErr == nil && !tt.locked && !tt.dryRun {
+				myMock.ExpectBegin()
+				if !tt.failA {
+					exec := myMock.ExpectExec(InsertStatement("metric_a", "count", false)).WithArgs("2024-01-15", 3)
+					if tt.insertErr != nil {
+						exec.WillReturnError(tt.insertErr)
+						myMock.ExpectRollback()
+					} else {
+						exec.WillReturnResult(sqlmock.NewResult(1, 1))
+					}
+				}
+				if tt.insertErr == nil {
+					myMock.ExpectExec(InsertStatement("metric_b", "count", false)).WithArgs("2024-01-15", 5).
+						WillReturnResult(sqlmock.NewResult(1, 1))
+					myMock.ExpectCommit()
+				}
+			}
+
+			history := &fakeHistory{}
+			var fallback *fakeFallback
+			writeFailed := false
+			var finished []Result
+			job := Job{
+				Sources:         []string{"pg"},
+				Destinations:    []string{"mysql"},
+				OpenSource:      func(string) (*sql.DB, error) { return pg, nil },
+				OpenDestination: func(string) (*sql.DB, error) { return my, nil },
+				Queries: []Query{
+					{Name: "a", Collect: func(context.Context, []*sql.DB) (Result, error) {
+						if tt.failA {
+							return Result{}, errQuery
+						}
+						return Result{Name: "a", Table: "metric_a", Count: 3}, nil
+					}},
+					{Name: "b", Collect: func(context.Context, []*sql.DB) (Result, error) {
+						return Result{Name: "b", Table: "metric_b", Count: 5}, nil
+					}},
+				},
+				Derived: tt.derived,
+				Workers: 1,
+				DryRun:  tt.dryRun,
+				History: history,
+				WriteFailed: func(context.Context, string, []Result, error) {
+					writeFailed = true
+				},
+				Finish: func(_ context.Context, _ *sql.DB, written []Result) []error {
+					finished = written
+					return nil
+				},
+			}
+			if tt.fallback {
+				fallback = &fakeFallback{}
+				job.Fallback = fallback
+			}
+			if tt.locked {
+				job.Lock = func(context.Context, *sql.DB) (func(), bool, error) { return nil, false, nil }
+			}
+
+			s, err := job.Run(context.Background(), "2024-01-15")
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("Run() error = %v, want %v", err, want)
+				}
+			}
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+			if s.MetricsSucceeded != tt.wantSucceeded || !reflect.DeepEqual(s.FailedMetrics, tt.wantFailed) {
+				t.Errorf("Run() succeeded %d, failed %v; want %d, %v", s.MetricsSucceeded, s.FailedMetrics, tt.wantSucceeded, tt.wantFailed)
+			}
+			if !reflect.DeepEqual(s.Counts, tt.wantCounts) {
+				t.Errorf("Counts = %v, want %v", s.Counts, tt.wantCounts)
+			}
+			if tt.locked && s.MetricsAttempted != 0 {
+				t.Errorf("MetricsAttempted = %d, want 0 for a skipped run", s.MetricsAttempted)
+			}
+			if fallback != nil && len(fallback.buffered) != tt.wantBuffered {
+				t.Errorf("buffered %d results, want %d", len(fallback.buffered), tt.wantBuffered)
+			}
+			if fallback != nil && fallback.flushed != (tt.pingErr == nil) {
+				t.Errorf("flushed = %v, want it only with a reachable destination", fallback.flushed)
+			}
+			if writeFailed != tt.wantWriteFailed {
+				t.Errorf("WriteFailed called = %v, want %v", writeFailed, tt.wantWriteFailed)
+			}
+			if history.started != tt.wantHistory || (tt.wantHistory && (history.finished == nil || history.finished.MetricsSucceeded != tt.wantSucceeded)) {
+				t.Errorf("history = %+v, want recorded %v with %d succeeded", history, tt.wantHistory, tt.wantSucceeded)
+			}
+			if !tt.dryRun && !tt.locked && tt.wantErr == nil && len(finished) != tt.wantSucceeded {
+				t.Errorf("Finish got %d results, want %d", len(finished), tt.wantSucceeded)
+			}
+			for _, mock := range []sqlmock.Sqlmock{pgMock, myMock} {
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestJobRunMaxDuration(t *testing.T) {
+	job := Job{
+		MaxDuration: 20 * time.Millisecond,
+		Queries: []Query{{Name: "slow", Collect: func(ctx context.Context, _ []*sql.DB) (Result, error) {
+			<-ctx.Done()
+			return Result{}, ctx.Err()
+		}}},
+		Workers: 1,
+	}
+	_, err := job.Run(context.Background(), "2024-01-15")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run() error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,116 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Writer inserts metric rows into MySQL.
+type Writer struct {
+	// Upsert silently overwrites an existing row for the date, so re-running
+	// a transfer for the same day replaces the earlier values instead of
+	// failing.
+	Upsert bool
+	// Timeout bounds each statement; zero disables the limit.
+	Timeout time.Duration
+}
+
+// Execer is satisfied by both *sql.DB and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// InsertRow stores value for date in the given column of table.
+func (w Writer) InsertRow(ctx context.Context, db Execer, table, column, date string, value any) (err error) {
+	ctx, span := tracer.Start(ctx, "insertToMySQL", trace.WithAttributes(
+		attribute.String("db.system", "mysql"),
+		attribute.String("oula.mysql_table", table),
+		attribute.String("oula.date", date),
+		attribute.String("oula."+column, fmt.Sprint(value)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	query := InsertStatement(table, column, w.Upsert)
+	slog.Debug("Executing insert", "statement", query, "date", date, column, value)
+	if _, err := db.ExecContext(ctx, query, date, value); err != nil {
+		return fmt.Errorf("failed to insert data to MySQL: %s, error: %w", query, ContextError(ctx, err))
+	}
+	slog.Info("Inserted metric", "table", table, "date", date, column, value)
+	return nil
+}
+
+// InsertAll writes results in a single transaction, rolling everything back
+// if any insert fails.
+func (w Writer) InsertAll(ctx context.Context, db *sql.DB, date string, results []Result) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin MySQL transaction: %w", err)
+	}
+	for _, r := range results {
+		row := Writer{Upsert: w.Upsert || r.Upsert, Timeout: w.Timeout}
+		if err := row.InsertRow(ctx, tx, r.Table, r.Column(), date, r.Stored()); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				slog.Error("Failed to roll back MySQL transaction", "error", rbErr)
+			}
+			return fmt.Errorf("%s: %w (transaction rolled back)", r.Table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit MySQL transaction: %w", err)
+	}
+	return nil
+}
+
+// InsertToAll runs InsertAll against every destination concurrently and
+// returns one error per destination that failed. Each destination commits
+// or rolls back on its own, so a failure leaves the others written; the
+// write is only successful when the returned slice is empty.
+func (w Writer) InsertToAll(ctx context.Context, dbs []*sql.DB, date string, results []Result) []error {
+	if len(dbs) == 1 {
+		if err := w.InsertAll(ctx, dbs[0], date, results); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+
+	errs := make([]error, len(dbs))
+	var wg sync.WaitGroup
+	for i, db := range dbs {
+		wg.Add(1)
+		go func(i int, db *sql.DB) {
+			defer wg.Done()
+			if err := w.InsertAll(ctx, db, date, results); err != nil {
+				errs[i] = fmt.Errorf("destination #%d: %w", i+1, err)
+			}
+		}(i, db)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// InsertStatement is the statement that stores one row of table, replacing
+// an existing row for the date when upsert is set.
+func InsertStatement(table, column string, upsert bool) string {
+	query := fmt.Sprintf("INSERT INTO %s (date, %s) VALUES (?, ?)", table, column)
+	if upsert {
+		query += fmt.Sprintf(" ON DUPLICATE KEY UPDATE %s = VALUES(%s)", column, column)
+	}
+	return query
+}
@@ -0,0 +1,108 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestInsertStatement(t *testing.T) {
+	tests := []struct {
+		column string
+		upsert bool
+		want   string
+	}{
+		{"count", false, "INSERT INTO t (date, count) VALUES (?, ?)"},
+		{"count", true, "INSERT INTO t (date, count) VALUES (?, ?) ON DUPLICATE KEY UPDATE count = VALUES(count)"},
+		{"value", true, "INSERT INTO t (date, value) VALUES (?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value)"},
+	}
+	for _, tt := range tests {
+		if got := InsertStatement("t", tt.column, tt.upsert); got != tt.want {
+			t.Errorf("InsertStatement(t, %s, %v) = %q, want %q", tt.column, tt.upsert, got, tt.want)
+		}
+	}
+}
+
+func TestInsertAll(t *testing.T) {
+	results := []Result{
+		{Name: "a", Table: "metric_a", Count: 3},
+		{Name: "b", Table: "metric_b", Value: 1.5, Upsert: true},
+	}
+	errDuplicate := errors.New("duplicate entry")
+
+	tests := []struct {
+		name    string
+		upsert  bool
+		failB   bool
+		wantErr bool
+	}{
+		{name: "committed"},
+		{name: "upsert", upsert: true},
+		{name: "rolled back", failB: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectBegin()
+			mock.ExpectExec(InsertStatement("metric_a", "count", tt.upsert)).WithArgs("2024-01-15", 3).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			// b upserts whatever the Writer says.
+			insertB := mock.ExpectExec(InsertStatement("metric_b", "value", true)).WithArgs("2024-01-15", 1.5)
+			if tt.failB {
+				insertB.WillReturnError(errDuplicate)
+				mock.ExpectRollback()
+			} else {
+				insertB.WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			}
+
+			err := Writer{Upsert: tt.upsert}.InsertAll(context.Background(), db, "2024-01-15", results)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errDuplicate) {
+				t.Errorf("error = %v, want it to wrap %v", err, errDuplicate)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestInsertToAll(t *testing.T) {
+	results := []Result{{Name: "a", Table: "metric_a", Count: 3}}
+	var dbs []*sql.DB
+	var mocks []sqlmock.Sqlmock
+	for i := 0; i < 3; i++ {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		exec := mock.ExpectExec(InsertStatement("metric_a", "count", false)).WithArgs("2024-01-15", 3)
+		if i == 1 {
+			exec.WillReturnError(errors.New("table is read only"))
+			mock.ExpectRollback()
+		} else {
+			exec.WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+		}
+		dbs = append(dbs, db)
+		mocks = append(mocks, mock)
+	}
+
+	errs := Writer{}.InsertToAll(context.Background(), dbs, "2024-01-15", results)
+	if len(errs) != 1 {
+		t.Fatalf("errors = %v, want one for destination #2", errs)
+	}
+	if got := errs[0].Error(); !strings.HasPrefix(got, "destination #2: ") {
+		t.Errorf("error = %q, want it to name destination #2", got)
+	}
+	for i, mock := range mocks {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("destination #%d: %v", i+1, err)
+		}
+	}
+}
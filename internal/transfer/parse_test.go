@@ -0,0 +1,129 @@
+package transfer
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseExecutionTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		hour    int
+		minute  int
+		wantErr bool
+	}{
+		{name: "valid", in: "23:00", hour: 23},
+		{name: "midnight", in: "00:00"},
+		{name: "leading zero", in: "09:05", hour: 9, minute: 5},
+		{name: "single digit hour", in: "9:05", hour: 9, minute: 5},
+		{name: "last minute of day", in: "23:59", hour: 23, minute: 59},
+		{name: "leading and trailing whitespace", in: "  07:45\t", hour: 7, minute: 45},
+		{name: "whitespace before zone", in: "07:45   UTC", hour: 7, minute: 45},
+
+		{name: "empty", in: "", wantErr: true},
+		{name: "only whitespace", in: " \t ", wantErr: true},
+		{name: "not a time", in: "abc", wantErr: true},
+		{name: "no colon", in: "2300", wantErr: true},
+		{name: "single digit minute", in: "23:0", wantErr: true},
+		{name: "three digit hour", in: "023:00", wantErr: true},
+		{name: "seconds", in: "23:00:00", wantErr: true},
+		{name: "space around colon", in: "23 : 00", wantErr: true},
+		{name: "letters in minute", in: "23:0a", wantErr: true},
+		{name: "plus sign", in: "+1:30", wantErr: true},
+		{name: "negative minute", in: "1:-3", wantErr: true},
+
+		{name: "negative hour", in: "-1:30", wantErr: true},
+
+		{name: "hour 24", in: "24:00", wantErr: true},
+		{name: "minute 60", in: "23:60", wantErr: true},
+		{name: "hour out of range", in: "25:00", wantErr: true},
+		{name: "minute out of range", in: "12:61", wantErr: true},
+		{name: "both out of range", in: "25:61", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hour, minute, _, err := ParseExecutionTime(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseExecutionTime(%q) = %02d:%02d, want error", tt.in, hour, minute)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseExecutionTime(%q): %v", tt.in, err)
+			}
+			if hour != tt.hour || minute != tt.minute {
+				t.Errorf("ParseExecutionTime(%q) = %02d:%02d, want %02d:%02d", tt.in, hour, minute, tt.hour, tt.minute)
+			}
+		})
+	}
+}
+
+// executionTimeFormat is the HH:MM part ParseExecutionTime accepts; an
+// optional timezone may follow it.
+var executionTimeFormat = regexp.MustCompile(`^[0-9]{1,2}:[0-9]{2}$`)
+
+func FuzzParseExecutionTime(f *testing.F) {
+	for _, seed := range []string{
+		"23:00", "00:00", "9:05", "23:00 UTC", "08:30 Asia/Shanghai",
+		"24:00", "23:60", "-1:30", "+1:30", "abc", "", " ", "1:2:3",
+		"23:00 UTC extra", "２３:００", "23:00\x00", "23:00 ../../etc/passwd",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		hour, minute, _, err := ParseExecutionTime(s)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(s)
+		if len(fields) == 0 || len(fields) > 2 || !executionTimeFormat.MatchString(fields[0]) {
+			t.Fatalf("ParseExecutionTime(%q) accepted input that is not HH:MM [Zone]", s)
+		}
+		if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+			t.Fatalf("ParseExecutionTime(%q) = %d:%d, out of range", s, hour, minute)
+		}
+	})
+}
+
+func TestParseExecutionTimeZone(t *testing.T) {
+	tests := []struct {
+		in       string
+		hour     int
+		minute   int
+		wantZone string
+		wantErr  bool
+	}{
+		{in: "23:00", hour: 23},
+		{in: "23:00 UTC", hour: 23, wantZone: "UTC"},
+		{in: "08:30 Asia/Shanghai", hour: 8, minute: 30, wantZone: "Asia/Shanghai"},
+		{in: "06:15 America/New_York", hour: 6, minute: 15, wantZone: "America/New_York"},
+		{in: "23:00 Mars/Olympus", wantErr: true},
+		{in: "23:00 UTC extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			hour, minute, loc, err := ParseExecutionTime(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseExecutionTime(%q) succeeded, want error", tt.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseExecutionTime(%q): %v", tt.in, err)
+			}
+			if hour != tt.hour || minute != tt.minute {
+				t.Errorf("ParseExecutionTime(%q) = %02d:%02d, want %02d:%02d", tt.in, hour, minute, tt.hour, tt.minute)
+			}
+			switch {
+			case tt.wantZone == "" && loc != nil:
+				t.Errorf("ParseExecutionTime(%q) zone = %s, want none", tt.in, loc)
+			case tt.wantZone != "" && (loc == nil || loc.String() != tt.wantZone):
+				t.Errorf("ParseExecutionTime(%q) zone = %v, want %s", tt.in, loc, tt.wantZone)
+			}
+		})
+	}
+}
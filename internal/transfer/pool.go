@@ -0,0 +1,113 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// collect calls Collect for every metric using a pool of workers goroutines
+// and returns the successful results sorted by metric name. A failing metric
+// does not stop the others; once ctx is cancelled the metrics not yet
+// started are skipped. All failures are returned together as a *MultiError.
+func collect(ctx context.Context, date string, metrics []Metric, workers int) ([]Result, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	workers = min(workers, len(metrics))
+
+	jobs := make(chan Metric, len(metrics))
+	for _, m := range metrics {
+		jobs <- m
+	}
+	close(jobs)
+
+	var (
+		mu      sync.Mutex
+		results []Result
+		errs    []error
+		timings []metricTiming
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range jobs {
+				if err := ctx.Err(); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: skipped: %w", m.Name, err))
+					mu.Unlock()
+					continue
+				}
+				started := time.Now()
+				r, err := m.Collect()
+				elapsed := time.Since(started)
+				mu.Lock()
+				timings = append(timings, metricTiming{m.Name, elapsed})
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					results = append(results, r)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	logTimings(date, timings)
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	if len(errs) == 0 {
+		return results, nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return results, &MultiError{Errors: errs}
+}
+
+// metricTiming is how long one metric took to collect, retries included.
+type metricTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// logTimings logs the metric query durations of a run, slowest first.
+func logTimings(date string, timings []metricTiming) {
+	if len(timings) == 0 {
+		return
+	}
+	sort.SliceStable(timings, func(i, j int) bool { return timings[i].duration > timings[j].duration })
+	attrs := make([]any, 0, len(timings)+2)
+	attrs = append(attrs, "date", date, "slowest", timings[0].name)
+	for _, t := range timings {
+		attrs = append(attrs, slog.Int64(t.name+"_ms", t.duration.Milliseconds()))
+	}
+	slog.Info("Metric query timings", attrs...)
+}
+
+// MultiError collects the failures of several metrics collected
+// concurrently. errors.Is and errors.As see every wrapped error.
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d metrics failed:", len(e.Errors))
+	for _, err := range e.Errors {
+		b.WriteString("\n\t")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
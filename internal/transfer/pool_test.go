@@ -0,0 +1,92 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// countMetrics returns metrics named names whose Collect counts its calls in
+// calls and fails for the names in fail.
+func countMetrics(names []string, fail map[string]bool, calls *atomic.Int32, errBoom error) []Metric {
+	var metrics []Metric
+	for _, name := range names {
+		name := name
+		metrics = append(metrics, Metric{Name: name, Collect: func() (Result, error) {
+			calls.Add(1)
+			if fail[name] {
+				return Result{}, fmt.Errorf("%s: %w", name, errBoom)
+			}
+			return Result{Name: name, Count: len(name)}, nil
+		}})
+	}
+	return metrics
+}
+
+func TestCollect(t *testing.T) {
+	names := []string{"c", "a", "e", "b", "d"}
+	errBoom := errors.New("boom")
+
+	tests := []struct {
+		name      string
+		workers   int
+		fail      map[string]bool
+		wantNames []string
+		wantErrs  int
+	}{
+		{name: "one worker", workers: 1, wantNames: []string{"a", "b", "c", "d", "e"}},
+		{name: "fewer workers than metrics", workers: 3, wantNames: []string{"a", "b", "c", "d", "e"}},
+		{name: "more workers than metrics", workers: 50, wantNames: []string{"a", "b", "c", "d", "e"}},
+		{name: "zero workers", workers: 0, wantNames: []string{"a", "b", "c", "d", "e"}},
+		{name: "failures", workers: 3, fail: map[string]bool{"b": true, "e": true}, wantNames: []string{"a", "c", "d"}, wantErrs: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			results, err := collect(context.Background(), "2024-01-15", countMetrics(names, tt.fail, &calls, errBoom), tt.workers)
+
+			if got := int(calls.Load()); got != len(names) {
+				t.Errorf("Collect called %d times, want %d", got, len(names))
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.Name)
+			}
+			if !reflect.DeepEqual(got, tt.wantNames) {
+				t.Errorf("results = %v, want %v", got, tt.wantNames)
+			}
+			if tt.wantErrs == 0 {
+				if err != nil {
+					t.Fatalf("error = %v, want nil", err)
+				}
+				return
+			}
+			var multi *MultiError
+			if !errors.As(err, &multi) || len(multi.Errors) != tt.wantErrs {
+				t.Fatalf("error = %v, want a MultiError with %d errors", err, tt.wantErrs)
+			}
+			if !errors.Is(err, errBoom) {
+				t.Errorf("errors.Is(%v, errBoom) = false", err)
+			}
+		})
+	}
+}
+
+func TestCollectCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var calls atomic.Int32
+	results, err := collect(ctx, "2024-01-15", countMetrics([]string{"a", "b"}, nil, &calls, nil), 2)
+	if n := calls.Load(); n != 0 {
+		t.Errorf("Collect called %d times after cancellation", n)
+	}
+	if len(results) != 0 {
+		t.Errorf("results = %v, want none", results)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+}
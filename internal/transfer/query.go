@@ -0,0 +1,79 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer delegates to the global provider, so spans are exported once the
+// binary installs one.
+var tracer = otel.Tracer("oula-transfer")
+
+// Scalar is a single-value query result type.
+type Scalar interface {
+	~int64 | ~float64 | ~string
+}
+
+// QueryScalar runs a single-value query with args bound to its $n
+// placeholders, bounded by timeout. A NULL result is reported as an error
+// rather than silently stored as zero.
+func QueryScalar[T Scalar](ctx context.Context, db *sql.DB, timeout time.Duration, query string, args ...any) (zero T, err error) {
+	ctx, span := tracer.Start(ctx, "queryCount", trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", query),
+	))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := WithTimeout(ctx, timeout)
+	defer cancel()
+
+	slog.Debug("Executing query", "query", query, "args", args)
+	// Scanning into a pointer leaves it nil for NULL.
+	var v *T
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&v); err != nil {
+		return zero, fmt.Errorf("failed to execute query: %s, error: %w", query, ContextError(ctx, err))
+	}
+	if v == nil {
+		return zero, fmt.Errorf("query returned NULL: %s", query)
+	}
+	slog.Debug("Query result", "query", query, "value", *v)
+	span.SetAttributes(attribute.String("oula.value", fmt.Sprint(*v)))
+	return *v, nil
+}
+
+// ContextError makes sure an error caused by ctx expiring wraps ctx.Err(),
+// since drivers report cancellation in their own terms.
+func ContextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
+}
+
+// WithTimeout bounds a single database call by timeout; zero disables the
+// limit.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// endSpan records err on span, if any, and ends it. The errors here come
+// from statements on open handles and carry no DSN.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,135 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newMockDB returns a sqlmock database that matches statements literally.
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+const (
+	countQuery  = "SELECT COUNT(*) FROM machine"
+	countInsert = "INSERT INTO active_machines_count_aleo (date, count) VALUES (?, ?)"
+)
+
+func TestQueryScalar(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		want    int64
+		wantErr bool
+	}{
+		{name: "value", rows: sqlmock.NewRows([]string{"count"}).AddRow(42), want: 42},
+		{name: "NULL", rows: sqlmock.NewRows([]string{"count"}).AddRow(nil), wantErr: true},
+		{name: "no rows", rows: sqlmock.NewRows([]string{"count"}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(countQuery).WillReturnRows(tt.rows)
+			got, err := QueryScalar[int64](context.Background(), db, 0, countQuery)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("QueryScalar = %d, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("QueryScalar = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	db, mock := newMockDB(t)
+
+	t.Run("query", func(t *testing.T) {
+		mock.ExpectQuery(countQuery).
+			WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		started := time.Now()
+		_, err := QueryScalar[int64](context.Background(), db, timeout, countQuery)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+		}
+		if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+			t.Errorf("query returned after %s, want about the timeout", elapsed)
+		}
+	})
+
+	t.Run("insert", func(t *testing.T) {
+		mock.ExpectExec(countInsert).
+			WillDelayFor(time.Second).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		err := Writer{Timeout: timeout}.InsertRow(context.Background(), db, "active_machines_count_aleo", "count", "2024-01-15", 1)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+		}
+	})
+}
+
+func TestContextCancelled(t *testing.T) {
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+		call   func(ctx context.Context, db *sql.DB) error
+	}{
+		{
+			name: "query",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(countQuery).WillDelayFor(time.Minute).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			},
+			call: func(ctx context.Context, db *sql.DB) error {
+				_, err := QueryScalar[int64](ctx, db, 0, countQuery)
+				return err
+			},
+		},
+		{
+			name: "insert",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(countInsert).WillDelayFor(time.Minute).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			call: func(ctx context.Context, db *sql.DB) error {
+				return Writer{}.InsertRow(ctx, db, "active_machines_count_aleo", "count", "2024-01-15", 1)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+
+			started := time.Now()
+			err := tt.call(ctx, db)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("error = %v, want context.Canceled", err)
+			}
+			if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+				t.Errorf("returned after %s, want soon after the cancel at 20ms", elapsed)
+			}
+		})
+	}
+}
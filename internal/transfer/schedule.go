@@ -0,0 +1,40 @@
+package transfer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseExecutionTime parses an "HH:MM" time of day, optionally followed by an
+// IANA zone name such as "23:00 Asia/Shanghai". The returned location is nil
+// when the string carries no zone, leaving the caller to pick a default.
+func ParseExecutionTime(s string) (int, int, *time.Location, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, 0, nil, fmt.Errorf("invalid execution time %q, expected HH:MM [Zone]", s)
+	}
+
+	hh, mm, ok := strings.Cut(fields[0], ":")
+	if !ok || len(hh) == 0 || len(hh) > 2 || len(mm) != 2 {
+		return 0, 0, nil, fmt.Errorf("invalid execution time %q, expected HH:MM", s)
+	}
+	hour, err := strconv.Atoi(hh)
+	if err != nil || hour < 0 || hour > 23 || strings.ContainsAny(hh, "+-") {
+		return 0, 0, nil, fmt.Errorf("invalid hour in execution time %q", s)
+	}
+	minute, err := strconv.Atoi(mm)
+	if err != nil || minute < 0 || minute > 59 || strings.ContainsAny(mm, "+-") {
+		return 0, 0, nil, fmt.Errorf("invalid minute in execution time %q", s)
+	}
+
+	var loc *time.Location
+	if len(fields) == 2 {
+		loc, err = time.LoadLocation(fields[1])
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("invalid timezone in execution time %q: %w", s, err)
+		}
+	}
+	return hour, minute, loc, nil
+}
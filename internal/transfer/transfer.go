@@ -0,0 +1,96 @@
+// Package transfer runs a day's metric transfer from PostgreSQL to MySQL.
+// A Job connects to the databases, collects every metric on a worker pool
+// and writes the day's results together; Run is the query and write phases
+// on their own. How a metric is queried and what else happens to the
+// results are up to the caller, so the package reads no flags of its own.
+package transfer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// Result is a collected metric waiting to be written to MySQL.
+type Result struct {
+	Name  string
+	Table string
+	Count int
+	// Value, when non-nil, is stored in the table's value column instead of
+	// Count in its count column.
+	Value any
+	// Upsert overwrites an existing row regardless of Writer.Upsert.
+	Upsert bool
+}
+
+// Column is the MySQL column r is stored in.
+func (r Result) Column() string {
+	if r.Value != nil {
+		return "value"
+	}
+	return "count"
+}
+
+// Stored is the value written to r's column.
+func (r Result) Stored() any {
+	if r.Value != nil {
+		return r.Value
+	}
+	return r.Count
+}
+
+// Metric is one metric of a run.
+type Metric struct {
+	Name string
+	// Collect queries the metric. It runs on the worker pool, so anything it
+	// shares with other metrics must be guarded.
+	Collect func() (Result, error)
+}
+
+// Options configures Run.
+type Options struct {
+	// Workers is how many metrics are collected at the same time.
+	Workers int
+	// DryRun logs the results instead of writing them.
+	DryRun bool
+	// Prepare runs between the query and write phases. It may drop results
+	// and add derived ones; its errors fail the run but not the results it
+	// returns.
+	Prepare func(ctx context.Context, results []Result) ([]Result, []error)
+	// Write stores the results. An error fails all of them; warnings fail
+	// the run without failing the results.
+	Write func(ctx context.Context, results []Result) (warnings []error, err error)
+}
+
+// Run collects metrics for date and writes the results with opts.Write. It
+// returns the results that were written, or would have been in a dry run,
+// along with every error the run met. A failing metric is skipped and the
+// others are still written.
+func Run(ctx context.Context, date string, metrics []Metric, opts Options) ([]Result, error) {
+	var errs []error
+	results, err := collect(ctx, date, metrics, opts.Workers)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if opts.Prepare != nil {
+		var prepareErrs []error
+		results, prepareErrs = opts.Prepare(ctx, results)
+		errs = append(errs, prepareErrs...)
+	}
+
+	if opts.DryRun {
+		for _, r := range results {
+			slog.InfoContext(ctx, "Dry run, skipping insert", "metric_name", r.Name, "table", r.Table, "date", date, r.Column(), r.Stored())
+		}
+		return results, errors.Join(errs...)
+	}
+	if len(results) == 0 || opts.Write == nil {
+		return nil, errors.Join(errs...)
+	}
+	warnings, err := opts.Write(ctx, results)
+	errs = append(errs, warnings...)
+	if err != nil {
+		return nil, errors.Join(append(errs, err)...)
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,129 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	errBoom := errors.New("boom")
+	errWrite := errors.New("write failed")
+	errWarn := errors.New("extra copy failed")
+	errPrepare := errors.New("anomaly")
+
+	tests := []struct {
+		name        string
+		fail        map[string]bool
+		opts        Options
+		wantWritten []string
+		wantCalls   int
+		wantErrs    []error
+	}{
+		{
+			name:        "written",
+			wantWritten: []string{"a", "b"},
+			wantCalls:   1,
+		},
+		{
+			name:        "failing metric is skipped",
+			fail:        map[string]bool{"a": true},
+			wantWritten: []string{"b"},
+			wantCalls:   1,
+			wantErrs:    []error{errBoom},
+		},
+		{
+			name:     "nothing collected is not written",
+			fail:     map[string]bool{"a": true, "b": true},
+			wantErrs: []error{errBoom},
+		},
+		{
+			name:        "dry run",
+			opts:        Options{DryRun: true},
+			wantWritten: []string{"a", "b"},
+		},
+		{
+			name: "prepare drops and derives",
+			opts: Options{Prepare: func(_ context.Context, results []Result) ([]Result, []error) {
+				return []Result{results[1], {Name: "derived"}}, []error{errPrepare}
+			}},
+			wantWritten: []string{"b", "derived"},
+			wantCalls:   1,
+			wantErrs:    []error{errPrepare},
+		},
+		{
+			name: "write error fails every result",
+			opts: Options{Write: func(context.Context, []Result) ([]error, error) {
+				return []error{errWarn}, errWrite
+			}},
+			wantCalls: 1,
+			wantErrs:  []error{errWarn, errWrite},
+		},
+		{
+			name: "write warnings keep the results",
+			opts: Options{Write: func(context.Context, []Result) ([]error, error) {
+				return []error{errWarn}, nil
+			}},
+			wantWritten: []string{"a", "b"},
+			wantCalls:   1,
+			wantErrs:    []error{errWarn},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var collected, writes atomic.Int32
+			opts := tt.opts
+			write := opts.Write
+			opts.Write = func(ctx context.Context, results []Result) ([]error, error) {
+				writes.Add(1)
+				if write != nil {
+					return write(ctx, results)
+				}
+				return nil, nil
+			}
+
+			written, err := Run(context.Background(), "2024-01-15", countMetrics([]string{"a", "b"}, tt.fail, &collected, errBoom), opts)
+
+			var got []string
+			for _, r := range written {
+				got = append(got, r.Name)
+			}
+			if !reflect.DeepEqual(got, tt.wantWritten) {
+				t.Errorf("written = %v, want %v", got, tt.wantWritten)
+			}
+			if n := int(writes.Load()); n != tt.wantCalls {
+				t.Errorf("Write called %d times, want %d", n, tt.wantCalls)
+			}
+			if len(tt.wantErrs) == 0 && err != nil {
+				t.Errorf("error = %v, want nil", err)
+			}
+			for _, want := range tt.wantErrs {
+				if !errors.Is(err, want) {
+					t.Errorf("error = %v, want it to wrap %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResultColumn(t *testing.T) {
+	tests := []struct {
+		r          Result
+		wantColumn string
+		wantStored any
+	}{
+		{Result{Count: 3}, "count", 3},
+		{Result{Count: 3, Value: 1.5}, "value", 1.5},
+		{Result{Value: "aleo"}, "value", "aleo"},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Column(); got != tt.wantColumn {
+			t.Errorf("%+v.Column() = %s, want %s", tt.r, got, tt.wantColumn)
+		}
+		if got := tt.r.Stored(); got != tt.wantStored {
+			t.Errorf("%+v.Stored() = %v, want %v", tt.r, got, tt.wantStored)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"oula-transfer/internal/transfer"
+)
+
+// kafkaConfig says where publishToKafka sends each run's metrics.
+type kafkaConfig struct {
+	Brokers []string
+	Topic   string
+}
+
+func kafkaConfigFromFlags() kafkaConfig {
+	var brokers []string
+	for _, b := range strings.Split(*kafkaBrokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	return kafkaConfig{Brokers: brokers, Topic: *kafkaTopic}
+}
+
+// kafkaMessageKey is <date>:<metric_name>; with the default hash balancer
+// all values of a metric for a day land on the same partition.
+func kafkaMessageKey(row metricRow) string {
+	return row.Date + ":" + row.MetricName
+}
+
+// publishToKafka sends every row as a JSON message and waits until all
+// in-sync replicas have acknowledged the batch. kafka-go has no idempotent
+// producer, so a retried batch can be delivered twice; consumers should
+// deduplicate on the message key, which is stable for a date and metric.
+func publishToKafka(ctx context.Context, cfg kafkaConfig, rows []metricRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	msgs := make([]kafka.Message, 0, len(rows))
+	for _, row := range rows {
+		body, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(kafkaMessageKey(row)), Value: body, Time: time.Now()})
+	}
+
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// One synchronous batch per run, so the messages go out together.
+		BatchSize: len(msgs),
+	}
+	defer w.Close()
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("failed to publish metrics to Kafka topic %s: %w", cfg.Topic, transfer.ContextError(ctx, err))
+	}
+	slog.Info("Published metrics to Kafka", "topic", cfg.Topic, "rows", len(rows))
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"oula-transfer/internal/transfer"
+)
+
+// The local fallback buffer is a SQLite file with one table per MySQL
+// metric table: date as the primary key plus the count or value column.
+// Rows land there when MySQL is unreachable or the insert fails, and are
+// flushed by the next run that reaches MySQL.
+
+// openLocalBuffer opens the SQLite fallback file; tables are created on the
+// first write.
+func openLocalBuffer(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local fallback %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// localBuffer is the -localFallback file as the fallback of a transfer.
+type localBuffer struct {
+	db *sql.DB
+}
+
+func (b localBuffer) Flush(ctx context.Context, mysqlDB *sql.DB) error {
+	return flushLocalBuffer(ctx, b.db, mysqlDB)
+}
+
+func (b localBuffer) Buffer(ctx context.Context, date string, results []transfer.Result, cause error) error {
+	return fallBackLocally(ctx, b.db, date, results, cause)
+}
+
+// quoteSQLiteIdent quotes name as a SQLite identifier.
+func quoteSQLiteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// bufferLocally writes results for date into the SQLite fallback,
+// replacing any row already buffered for the same table and date.
+func bufferLocally(ctx context.Context, db *sql.DB, date string, results []transfer.Result) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, r := range results {
+		table, column := quoteSQLiteIdent(r.Table), r.Column()
+		// count keeps INTEGER affinity like the MySQL INT column; value has
+		// none so float and string metrics round-trip unchanged.
+		colType := ""
+		if column == "count" {
+			colType = " INTEGER"
+		}
+		ddl := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (date TEXT NOT NULL PRIMARY KEY, %s%s NOT NULL)", table, column, colType)
+		if _, err := tx.ExecContext(ctx, ddl); err != nil {
+			return fmt.Errorf("failed to create local table %s: %w", r.Table, err)
+		}
+		insert := fmt.Sprintf("INSERT OR REPLACE INTO %s (date, %s) VALUES (?, ?)", table, column)
+		if _, err := tx.ExecContext(ctx, insert, date, r.Stored()); err != nil {
+			return fmt.Errorf("failed to buffer %s locally: %w", r.Table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// flushLocalBuffer moves every buffered row into mysqlDB and removes it from
+// the SQLite file. Rows are upserted so a row that did reach MySQL before
+// the failure is overwritten rather than failing the flush. On error the
+// rows not yet written stay buffered for the next run, as they do when ctx
+// is cancelled.
+func flushLocalBuffer(ctx context.Context, sqliteDB, mysqlDB *sql.DB) error {
+	tables, err := localTables(ctx, sqliteDB)
+	if err != nil {
+		return fmt.Errorf("failed to list local fallback tables: %w", err)
+	}
+	flushed := 0
+	for _, table := range tables {
+		n, err := flushLocalTable(ctx, sqliteDB, mysqlDB, table)
+		flushed += n
+		if err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	if flushed > 0 {
+		slog.Info("Flushed locally buffered rows to MySQL", "rows", flushed, "tables", len(tables))
+	}
+	return nil
+}
+
+func localTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+func flushLocalTable(ctx context.Context, sqliteDB, mysqlDB *sql.DB, table string) (int, error) {
+	rows, err := sqliteDB.QueryContext(ctx, "SELECT * FROM "+quoteSQLiteIdent(table))
+	if err != nil {
+		return 0, err
+	}
+	cols, err := rows.Columns()
+	if err == nil && len(cols) != 2 {
+		err = fmt.Errorf("unexpected local table columns %v", cols)
+	}
+	if err != nil {
+		rows.Close()
+		return 0, err
+	}
+	type bufferedRow struct {
+		date  string
+		value any
+	}
+	var buffered []bufferedRow
+	for rows.Next() {
+		var r bufferedRow
+		if err := rows.Scan(&r.date, &r.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		buffered = append(buffered, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	w := transfer.Writer{Upsert: true, Timeout: *queryTimeout}
+	for i, r := range buffered {
+		if err := w.InsertRow(ctx, mysqlDB, table, cols[1], r.date, r.value); err != nil {
+			return i, err
+		}
+		if _, err := sqliteDB.ExecContext(ctx, "DELETE FROM "+quoteSQLiteIdent(table)+" WHERE date = ?", r.date); err != nil {
+			return i, fmt.Errorf("failed to remove flushed row %s: %w", r.date, err)
+		}
+	}
+	return len(buffered), nil
+}
+
+// fallBackLocally buffers results after a failed MySQL write. The run
+// counts as written once the rows are buffered; cause is only returned,
+// joined with the buffering error, if that fails too.
+func fallBackLocally(ctx context.Context, db *sql.DB, date string, results []transfer.Result, cause error) error {
+	if err := bufferLocally(ctx, db, date, results); err != nil {
+		return fmt.Errorf("%w; local fallback also failed: %v", cause, err)
+	}
+	slog.Warn("MySQL write failed, rows buffered in the local fallback",
+		"path", *localFallback, "date", date, "rows", len(results), "error", cause)
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"oula-transfer/internal/transfer"
+)
+
+func TestFlushLocalBuffer(t *testing.T) {
+	tests := []struct {
+		name       string
+		cancelled  bool
+		wantErr    error
+		wantLeft   int
+		wantInsert bool
+	}{
+		{name: "flushes", wantInsert: true},
+		{name: "cancelled", cancelled: true, wantErr: context.Canceled, wantLeft: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, err := openLocalBuffer(filepath.Join(t.TempDir(), "fallback.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer local.Close()
+			results := []transfer.Result{{Name: "metric_a", Table: "metric_a", Count: 3}}
+			if err := bufferLocally(context.Background(), local, "2024-01-15", results); err != nil {
+				t.Fatal(err)
+			}
+
+			my, myMock := newMockDB(t)
+			if tt.wantInsert {
+				myMock.ExpectExec(transfer.InsertStatement("metric_a", "count", true)).WithArgs("2024-01-15", int64(3)).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+
+			err = flushLocalBuffer(ctx, local, my)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("flushLocalBuffer() = %v, want %v", err, tt.wantErr)
+			}
+			var left int
+			if err := local.QueryRow(`SELECT count(*) FROM "metric_a"`).Scan(&left); err != nil {
+				t.Fatal(err)
+			}
+			if left != tt.wantLeft {
+				t.Errorf("%d rows left buffered, want %d", left, tt.wantLeft)
+			}
+			if err := myMock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+)
+
+// advisoryKey derives a stable lock key for one transfer date, so runs for
+// different dates (e.g. during a backfill) do not block each other.
+func advisoryKey(base int64, date string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(date))
+	return base ^ int64(h.Sum64())
+}
+
+// tryAdvisoryLock takes a session-level PostgreSQL advisory lock on a
+// dedicated connection. If the lock is acquired, the returned function
+// releases it and returns the connection to the pool.
+func tryAdvisoryLock(ctx context.Context, db *sql.DB, key int64) (func(), bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get connection for advisory lock: %w", err)
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("failed to acquire advisory lock: %w", err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			// Closing the session releases the lock anyway.
+			slog.Warn("Failed to release advisory lock", "key", key, "error", err)
+		}
+		conn.Close()
+	}, true, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// setupLogger installs the default slog logger for the given -logFormat and
+// -logLevel.
+func setupLogger(format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid logLevel %q, expected debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid logFormat %q, expected text or json", format)
+	}
+	slog.SetDefault(slog.New(tenantHandler{handler}))
+	return nil
+}
+
+// tenantHandler adds a tenant attribute to records logged with a tenant's
+// context, so one tenant's lines can be filtered out of the shared output.
+type tenantHandler struct {
+	slog.Handler
+}
+
+func (h tenantHandler) Handle(ctx context.Context, r slog.Record) error {
+	if t := tenantFromContext(ctx); t != nil {
+		r.AddAttrs(slog.String("tenant", t.Name))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h tenantHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return tenantHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h tenantHandler) WithGroup(name string) slog.Handler {
+	return tenantHandler{h.Handler.WithGroup(name)}
+}
+
+var exitHooks []func()
+
+// atExit registers f to run before the process exits, whether main returns
+// normally or through fatal. Hooks run in reverse order of registration.
+func atExit(f func()) {
+	exitHooks = append(exitHooks, f)
+}
+
+func runAtExit() {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	exitHooks = nil
+}
+
+// fatal logs msg at Error level, runs the exit hooks and exits with status 1.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	runAtExit()
+	os.Exit(1)
+}
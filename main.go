@@ -1,172 +1,642 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/lib/pq"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"oula-transfer/internal/transfer"
 )
 
 var (
-	executionTime = flag.String("executionTime", "23:00", "Time to execute the transfer in HH:MM format")
-	pgDsn         = flag.String("pgDsn", "", "PostgreSQL DSN")
-	mysqlDsn      = flag.String("mysqlDsn", "", "MySQL DSN")
+	configPath           = flag.String("config", "", "Path to a YAML or JSON config file; command-line flags take precedence")
+	tenantName           = flag.String("tenant", "", "Only run the tenant with this name from the config file's tenants section")
+	logFormat            = flag.String("logFormat", "text", "Log output format: text or json")
+	logLevel             = flag.String("logLevel", "info", "Log level: debug, info, warn or error")
+	showSample           = flag.Bool("sampleConfig", false, "Print a sample config file and exit")
+	showVersion          = flag.Bool("version", false, "Print the version, commit and build time and exit")
+	executionTimes       timeList
+	pgDsnList            dsnList
+	mysqlDsnList         dsnList
+	onlyMetrics          nameList
+	excludeMetrics       nameList
+	timezone             = flag.String("timezone", "", "IANA timezone for the execution time (e.g. Asia/Shanghai); defaults to the host timezone")
+	interval             = flag.Duration("interval", 0, "Run the transfer on a fixed interval (e.g. 6h) instead of at -executionTime")
+	pgDsn                = flag.String("pgDsn", "", "PostgreSQL DSN (falls back to -pgDsnFile, then $OULA_PG_DSN)")
+	mysqlDsn             = flag.String("mysqlDsn", "", "MySQL DSN (falls back to -mysqlDsnFile, then $OULA_MYSQL_DSN)")
+	pgDsnFile            = flag.String("pgDsnFile", "", "File holding the PostgreSQL DSN, e.g. a mounted secret; -pgDsn takes precedence")
+	mysqlDsnFile         = flag.String("mysqlDsnFile", "", "File holding the MySQL DSN, e.g. a mounted secret; -mysqlDsn takes precedence")
+	pgSSLMode            = flag.String("pgSSLMode", "", "PostgreSQL sslmode (disable, require, verify-ca, verify-full, ...); overrides the DSN")
+	pgSSLCert            = flag.String("pgSSLCert", "", "PostgreSQL client certificate file; overrides the DSN")
+	pgSSLKey             = flag.String("pgSSLKey", "", "PostgreSQL client private key file; overrides the DSN")
+	pgSSLRootCert        = flag.String("pgSSLRootCert", "", "PostgreSQL root CA certificate file; overrides the DSN")
+	mysqlTLSName         = flag.String("mysqlTLSName", "", "Name of the MySQL TLS config to use (registered from the -mysqlTLS* files, or one of true, skip-verify, preferred)")
+	mysqlTLSCert         = flag.String("mysqlTLSCert", "", "MySQL client certificate PEM file")
+	mysqlTLSKey          = flag.String("mysqlTLSKey", "", "MySQL client private key PEM file")
+	mysqlTLSCA           = flag.String("mysqlTLSCA", "", "MySQL CA certificate PEM file")
+	pgMaxOpenConns       = flag.Int("pgMaxOpenConns", 5, "Maximum open PostgreSQL connections (0 = unlimited)")
+	pgMaxIdleConns       = flag.Int("pgMaxIdleConns", 2, "Maximum idle PostgreSQL connections")
+	pgConnMaxLifetime    = flag.Duration("pgConnMaxLifetime", 30*time.Minute, "Maximum lifetime of a PostgreSQL connection (0 = unlimited)")
+	pgConnMaxIdleTime    = flag.Duration("pgConnMaxIdleTime", 5*time.Minute, "Maximum idle time of a PostgreSQL connection (0 = unlimited)")
+	mysqlMaxOpenConns    = flag.Int("mysqlMaxOpenConns", 5, "Maximum open MySQL connections (0 = unlimited)")
+	mysqlMaxIdleConns    = flag.Int("mysqlMaxIdleConns", 2, "Maximum idle MySQL connections")
+	mysqlConnMaxLifetime = flag.Duration("mysqlConnMaxLifetime", 30*time.Minute, "Maximum lifetime of a MySQL connection (0 = unlimited)")
+	mysqlConnMaxIdleTime = flag.Duration("mysqlConnMaxIdleTime", 5*time.Minute, "Maximum idle time of a MySQL connection (0 = unlimited)")
+	metricsFile          = flag.String("metricsFile", "", "YAML or JSON file with metric queries (name, query, mysqlTable); defaults to the built-in metrics")
+	queriesDir           = flag.String("queriesDir", "", "Directory of <metric_name>.sql files to use instead of the built-in metrics; each file's name is also its MySQL table")
+	pgSchema             = flag.String("pgSchema", "public", "PostgreSQL schema substituted for {{schema}} in the metric queries")
+	activeWindow         = flag.Duration("activeWindow", time.Hour, "Window of the recently_active_machines_count metric, substituted for {{activeWindowSeconds}} in queries")
+	lostUserDays         = flag.Int("lostUserDays", 1, "Days without machine activity after which lost_users_count counts a user as lost (1-365), substituted for {{lostUserDays}} in queries")
+	workers              = flag.Int("workers", 3, "Number of metric queries to run against PostgreSQL at the same time")
+	enableCache          = flag.Bool("enableCache", false, "Cache metric query results in memory so re-runs for the same date within -cacheTTL skip PostgreSQL")
+	cacheTTL             = flag.Duration("cacheTTL", time.Hour, "How long -enableCache keeps a query result")
+	maxRetries           = flag.Int("maxRetries", 3, "Maximum attempts for a PostgreSQL query that fails with a transient error")
+	retryDelay           = flag.Duration("retryBaseDelay", time.Second, "Base delay for exponential backoff between query retries")
+	connectTimeout       = flag.Duration("connectTimeout", 10*time.Second, "Timeout for establishing the database connections before a transfer (0 = no timeout)")
+	queryTimeout         = flag.Duration("queryTimeout", 30*time.Second, "Timeout for each PostgreSQL query and MySQL insert (0 = no timeout)")
+	maxTransferDuration  = flag.Duration("maxTransferDuration", 0, "Overall time budget for one transfer; when it runs out, in-flight queries and inserts are cancelled and the run fails (0 = no limit)")
+	slowQueryThreshold   = flag.Duration("slowQueryThreshold", 5*time.Second, "Log a warning with the full SQL of any metric query slower than this (0 = disabled)")
+	explain              = flag.Bool("explain", false, "Log the EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) plan of every metric query before running it; ANALYZE runs each query an extra time")
+	maxCount             = flag.Int("maxCount", 0, "Reject any metric count above this value as a likely query bug (0 = no upper bound)")
+	failOnZero           = flag.Bool("failOnZero", false, "Treat a zero count for any metric as a failure")
+	anomalyThreshold     = flag.Float64("anomalyThreshold", 50, "Warn when a metric changes by more than this percentage from the previous day (0 = disabled)")
+	anomalyAction        = flag.String("anomalyAction", "warn", "What to do with an anomalous metric: warn (insert anyway) or fail (skip the insert)")
+	once                 = flag.Bool("once", false, "Run the transfer immediately once and exit (non-zero status on failure)")
+	pidFile              = flag.String("pidFile", "", "Write the PID to this file and refuse to start if another running instance holds it")
+	advisoryLockKey      = flag.Int64("advisoryLockKey", 0, "Base key for a PostgreSQL advisory lock that lets only one instance transfer a given date (0 = disabled)")
+	pingOnly             = flag.Bool("pingOnly", false, "Check connectivity to both databases, print their versions and exit")
+	statusFlag           = flag.Bool("status", false, "Print the last run from the transfer_runs table and exit: 0 if it succeeded, 2 if it failed or is stale, 1 if there is none")
+	statusJSON           = flag.Bool("json", false, "Print -status as JSON")
+	staleRunAfter        = flag.Duration("staleRunAfter", 6*time.Hour, "With -status, a run still marked running after this long is treated as dead and exits 2 (0 = never)")
+	checkSchemaOnly      = flag.Bool("checkSchemaOnly", false, "Check PostgreSQL connectivity and that all MySQL tables exist, then exit (non-zero status on failure)")
+	checkQueries         = flag.Bool("checkQueries", false, "EXPLAIN every metric query against PostgreSQL to catch syntax and schema errors, then exit; no data is read or written")
+	date                 = flag.String("date", "", "Transfer data for a specific historical date (YYYY-MM-DD) once and exit")
+	fromDate             = flag.String("fromDate", "", "First day (YYYY-MM-DD) of an inclusive range to backfill; requires -toDate")
+	toDate               = flag.String("toDate", "", "Last day (YYYY-MM-DD) of an inclusive range to backfill; requires -fromDate")
+	upsert               = flag.Bool("upsert", false, "Overwrite an existing row for the same date instead of failing on duplicate key (makes re-runs idempotent)")
+	tablePrefix          = flag.String("tablePrefix", "", "Prefix added to every metric's MySQL table name, e.g. prod_; transfer_runs is not renamed")
+	tableSuffix          = flag.String("tableSuffix", "", "Suffix added to every metric's MySQL table name")
+	dlqPath              = flag.String("dlqPath", "", "SQLite file that keeps metric rows whose MySQL insert failed, for -replayDLQ; empty disables it")
+	replayDLQFlag        = flag.Bool("replayDLQ", false, "Retry the rows in -dlqPath against MySQL, remove the delivered ones and exit")
+	localFallback        = flag.String("localFallback", "", "SQLite file that buffers metric rows while MySQL is unreachable; they are flushed by the next run that reaches MySQL")
+	autoMigrate          = flag.Bool("autoMigrate", false, "Create any missing MySQL tables (metric tables and transfer_runs) at startup")
+	dryRun               = flag.Bool("dry-run", false, "Run the PostgreSQL queries and log what would be inserted without writing to MySQL")
+	simulate             = flag.Bool("simulate", false, "Insert synthetic counts instead of querying PostgreSQL, for testing the MySQL side")
+	simulateSeed         = flag.Int64("simulateSeed", 0, "Seed for -simulate so every run inserts the same counts (0 = random)")
+	csvOutput            = flag.String("csvOutput", "", "Append the metrics to this CSV file (date,metric_name,count) instead of writing them to MySQL")
+	copyQuery            = flag.String("copyQuery", "", "Query whose full result set is streamed to -copyOutput as CSV with PostgreSQL COPY on every run; {{schema}} and {{date}} are replaced")
+	copyOutput           = flag.String("copyOutput", "", "CSV file for -copyQuery; {{date}} in the path is replaced with the transfer date")
+	jsonOutput           = flag.String("jsonOutput", "", "Merge the metrics into the JSON array in this file, keyed on date and metric, instead of writing them to MySQL")
+	jsonPretty           = flag.Bool("jsonPretty", false, "Indent the -jsonOutput file")
+	s3Bucket             = flag.String("s3Bucket", "", "S3 bucket to upload each run's metrics to as JSON; empty disables the upload")
+	s3Prefix             = flag.String("s3Prefix", "", "Key prefix for S3 uploads; objects are stored as <prefix>/<date>/<hostname>_<timestamp>.json")
+	s3Region             = flag.String("s3Region", "", "AWS region of -s3Bucket; defaults to the region from the AWS config chain")
+	s3Only               = flag.Bool("s3Only", false, "Upload to S3 only and skip the MySQL inserts")
+	influxURL            = flag.String("influxURL", "", "InfluxDB 2 URL to write each run's metrics to; empty disables InfluxDB")
+	influxToken          = flag.String("influxToken", "", "InfluxDB API token")
+	influxOrg            = flag.String("influxOrg", "", "InfluxDB organization")
+	influxBucket         = flag.String("influxBucket", "", "InfluxDB bucket for the oula_metrics measurement")
+	redisAddr            = flag.String("redisAddr", "", "Redis address (host:port) to write each run's metrics to; empty disables Redis")
+	redisPassword        = flag.String("redisPassword", "", "Redis password")
+	kafkaBrokers         = flag.String("kafkaBrokers", "", "Comma-separated Kafka brokers to publish each run's metrics to; empty disables Kafka")
+	kafkaTopic           = flag.String("kafkaTopic", "", "Kafka topic for the metric messages")
+	kafkaOnly            = flag.Bool("kafkaOnly", false, "Publish to Kafka only and skip the MySQL inserts")
+	outputStdout         = flag.Bool("outputStdout", false, "Print each metric as a line of JSON on stdout; MySQL is still written unless no MySQL DSN is given")
+	reportFlag           = flag.Bool("report", false, "Print a Markdown report of the day's metrics, with changes from the previous day, after a successful transfer")
+	reportTemplate       = flag.String("reportTemplate", "", "text/template file to render the -report with instead of the built-in Markdown table")
+	reportWebhook        = flag.String("reportWebhook", "", "URL the rendered -report is POSTed to as JSON")
+	metricsAddr          = flag.String("metricsAddr", ":9090", "Address for the Prometheus /metrics endpoint; empty disables it")
+	pushgatewayURL       = flag.String("pushgatewayURL", "", "Prometheus Pushgateway URL to push run metrics to after every transfer (for -once jobs); empty disables pushing")
+	healthAddr           = flag.String("healthAddr", "", "Address for the /healthz, /readyz, /status and /transfer endpoints (e.g. :8080); empty disables them")
+	otelEndpoint         = flag.String("otelEndpoint", "", "OTLP/gRPC endpoint for OpenTelemetry traces (e.g. localhost:4317); empty disables tracing")
+	otelServiceName      = flag.String("otelServiceName", "oula-transfer", "Service name reported in OpenTelemetry traces")
+	alertWebhook         = flag.String("alertWebhook", "", "URL to POST a JSON alert to when a transfer fails; empty disables alerts")
+	slackWebhook         = flag.String("slackWebhook", "", "Slack Incoming Webhook URL notified when a transfer fails; empty disables Slack")
+	slackOnSuccess       = flag.Bool("slackOnSuccess", false, "Also notify -slackWebhook when a transfer succeeds")
+	dashboardURL         = flag.String("dashboardURL", "", "Dashboard URL linked from Slack notifications")
 )
 
+// metricQueries holds the metrics collected on every transfer, loaded once
+// at startup, and derivedMetrics those computed from them.
+var (
+	metricQueries  []MetricQuery
+	derivedMetrics []DerivedMetric
+)
+
+func init() {
+	flag.Var(&pgDsnList, "pgDsns", "Comma-separated PostgreSQL DSNs whose counts are summed, for sharded deployments; replaces -pgDsn")
+	flag.Var(&mysqlDsnList, "mysqlDsns", "Comma-separated MySQL DSNs that every metric is written to, e.g. a primary and a DR mirror; replaces -mysqlDsn")
+	flag.Var(&onlyMetrics, "metrics", "Comma-separated metric names to collect, skipping all others (and all derived metrics); combine with -date to re-run one metric for one day")
+	flag.Var(&excludeMetrics, "excludeMetrics", "Comma-separated metric names to skip, along with the derived metrics built on them; cannot be combined with -metrics")
+	flag.Var(&executionTimes, "executionTime", "When to execute the transfer: HH:MM, optionally followed by a zone (e.g. \"23:00 Asia/Shanghai\"), or a 5-field cron expression (e.g. \"0 */6 * * *\"); repeat for several schedules (default 23:00)")
+}
+
 func main() {
 	flag.Parse()
 
-	if *pgDsn == "" || *mysqlDsn == "" {
-		log.Println("PostgreSQL DSN and MySQL DSN must be provided.")
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	if *showSample {
+		if err := printSampleConfig(); err != nil {
+			fatal("Failed to print sample config", "error", err)
+		}
+		return
+	}
+
+	if *configPath != "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			fatal("Failed to load config", "error", err)
+		}
+		if err := applyConfig(cfg); err != nil {
+			fatal("Failed to apply config", "error", err)
+		}
+	}
+
+	// Keep credentials out of the process list by allowing them in a
+	// mounted secret file or the environment instead. The flag wins over
+	// the file, and the file over the environment.
+	for _, f := range []struct {
+		dsn, path *string
+		name      string
+	}{{pgDsn, pgDsnFile, "pgDsnFile"}, {mysqlDsn, mysqlDsnFile, "mysqlDsnFile"}} {
+		if *f.dsn == "" && *f.path != "" {
+			dsn, err := readDSNFile(*f.path)
+			if err != nil {
+				fatal("Failed to read DSN file", "flag", f.name, "error", err)
+			}
+			*f.dsn = dsn
+		}
+	}
+	*pgDsn = resolveFlag(*pgDsn, "OULA_PG_DSN")
+	*mysqlDsn = resolveFlag(*mysqlDsn, "OULA_MYSQL_DSN")
+
+	if err := setupLogger(*logFormat, *logLevel); err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+
+	if err := validateFlags(); err != nil {
+		slog.Error("Invalid configuration", "error", err)
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	loc, err := loadTimezone(*timezone)
+	if err != nil {
+		fatal("Invalid timezone", "error", err)
+	}
+
+	if *queriesDir != "" {
+		metricQueries, err = loadQueriesDir(*queriesDir)
+	} else {
+		metricQueries, err = loadMetricQueries(*metricsFile)
+	}
+	if err == nil {
+		metricQueries, derivedMetrics, err = splitDerivedMetrics(metricQueries)
+	}
+	if err == nil {
+		if *metricsFile == "" && *queriesDir == "" {
+			metricQueries = combinableMetrics(metricQueries, len(pgSources()))
+		}
+		err = checkAggregateSources(metricQueries, len(pgSources()))
+	}
+	if err == nil {
+		err = checkTenantMetrics()
+	}
+	if err == nil && (len(onlyMetrics) > 0 || len(excludeMetrics) > 0) {
+		metricQueries, err = filterMetrics(metricQueries, onlyMetrics, excludeMetrics)
+		// Derived metrics need their inputs, so -metrics skips them and
+		// -excludeMetrics skips those built on an excluded metric.
+		if len(onlyMetrics) > 0 {
+			derivedMetrics = nil
+		} else {
+			derivedMetrics = derivedWithInputs(derivedMetrics, metricQueries)
+		}
+	}
+	if err != nil {
+		fatal("Failed to load metric queries", "error", err)
+	}
+
+	// SIGTERM/SIGINT stop the scheduler; a transfer that is already running
+	// finishes its current metric before returning.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if *pidFile != "" {
+		if err := acquirePIDFile(*pidFile); err != nil {
+			fatal("Failed to acquire PID file", "error", err)
+		}
+		path := *pidFile
+		atExit(func() { os.Remove(path) })
+	}
+
+	shutdownTracer, err := initTracer(ctx, *otelEndpoint, *otelServiceName)
+	if err != nil {
+		fatal("Failed to initialise tracing", "error", err)
+	}
+	atExit(shutdownTracer)
+	defer runAtExit()
+
+	if *autoMigrate {
+		for _, target := range transferTargets() {
+			if err := migrateAll(ctx, target.mysqlDsns, target.queries, target.derived); err != nil {
+				fatal("MySQL schema migration failed", "error", target.wrap(err))
+			}
+		}
+	}
+
+	if *statusFlag {
+		code := runStatusCommand(ctx, *statusJSON)
+		runAtExit()
+		os.Exit(code)
+	}
+
+	if *pingOnly {
+		if err := runPingOnly(ctx); err != nil {
+			fatal("Connectivity check failed", "error", err)
+		}
+		slog.Info("Both databases are reachable")
+		return
+	}
+
+	if *replayDLQFlag {
+		if err := replayDLQ(ctx, *dlqPath); err != nil {
+			fatal("Dead-letter replay finished with errors", "error", err)
+		}
+		return
+	}
+
+	if *checkQueries {
+		if err := runCheckQueries(ctx); err != nil {
+			fatal("Query check failed", "error", err)
+		}
+		slog.Info("All metric queries are valid")
+		return
+	}
+
+	if *checkSchemaOnly {
+		if err := runCheckSchemaOnly(ctx); err != nil {
+			fatal("Schema check failed", "error", err)
+		}
+		slog.Info("PostgreSQL is reachable and all MySQL tables exist")
+		return
+	}
+
+	if !*simulate {
+		if err := checkQuerySyntaxAtStartup(ctx); err != nil {
+			fatal("Invalid metric query", "error", err)
+		}
+	}
+
+	// The servers only run for transfers; the one-shot commands above exit
+	// first, so running one next to the daemon cannot collide with its
+	// -metricsAddr or -healthAddr.
+	startMetricsServer(ctx, *metricsAddr)
+	startHealthServer(ctx, *healthAddr)
+
+	if *fromDate != "" || *toDate != "" {
+		if err := backfillRange(ctx, *fromDate, *toDate, loc); err != nil {
+			fatal("Backfill finished with errors", "error", err)
+		}
+		return
+	}
+
+	// A historical date is always a one-off backfill.
+	if *once || *date != "" {
+		forDate := time.Now().In(loc)
+		if *date != "" {
+			if forDate, err = parseDate(*date, loc); err != nil {
+				fatal("Invalid date", "error", err)
+			}
+		}
+		if err := startOnce(); err != nil {
+			fatal("Data transfer failed", "error", err)
+		}
+		err = runOnce(ctx, forDate)
+		finishOnce()
+		if err != nil {
+			fatal("Data transfer failed", "error", err)
+		}
+		return
+	}
+
+	if len(tenants) > 0 {
+		runTenantSchedulers(ctx, loc)
+	} else {
+		runScheduler(ctx, loc)
+	}
+	slog.Info("Shutting down")
+}
+
+// runScheduler sleeps until each scheduled execution time and runs a
+// transfer, returning once ctx is cancelled.
+func runScheduler(ctx context.Context, loc *time.Location) {
+	if *interval > 0 {
+		runInterval(ctx, *interval, loc)
+		return
+	}
+	scheduleTransfers(ctx, executionTimes.orDefault(), loc)
+}
+
+// scheduleTransfers runs a transfer at each of times until ctx is
+// cancelled.
+func scheduleTransfers(ctx context.Context, times []string, loc *time.Location) {
 	for {
-		now := time.Now()
-		execHour, execMinute := parseExecutionTime(*executionTime)
-		execution := time.Date(now.Year(), now.Month(), now.Day(), execHour, execMinute, 0, 0, now.Location())
-		if now.After(execution) {
-			execution = execution.Add(24 * time.Hour)
+		execution, err := nextExecution(times, loc)
+		if err != nil {
+			fatal("Failed to compute next execution time", "error", err)
 		}
-		time.Sleep(time.Until(execution))
+		slog.InfoContext(ctx, "Next transfer scheduled", "at", execution.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(execution))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		runScheduledTransfer(ctx, execution)
+	}
+}
 
-		transferData(*pgDsn, *mysqlDsn)
+// transferInProgress is set while a scheduled or manually triggered transfer
+// of the default configuration runs, so the scheduler skips a run instead of
+// overlapping one started from POST /transfer or still going from the last
+// tick. Tenants have their own flags; see startTenantTransfer.
+var (
+	transferMu         sync.Mutex
+	transferInProgress bool
+)
+
+// startTransfer claims transferInProgress, reporting false if it was
+// already set.
+func startTransfer() bool {
+	transferMu.Lock()
+	defer transferMu.Unlock()
+	if transferInProgress {
+		return false
 	}
+	transferInProgress = true
+	return true
 }
 
-func parseExecutionTime(timeStr string) (int, int) {
-	var hour, minute int
-	fmt.Sscanf(timeStr, "%d:%d", &hour, &minute)
-	return hour, minute
+func finishTransfer() {
+	transferMu.Lock()
+	defer transferMu.Unlock()
+	transferInProgress = false
 }
 
-func transferData(pgDsn, mysqlDsn string) {
-	log.Println("Starting data transfer...")
+// startOnce claims what runOnce transfers: transferInProgress and, in
+// tenant mode, every selected tenant. It claims nothing when one of them is
+// busy.
+func startOnce() error {
+	if !startTransfer() {
+		return errTransferInProgress
+	}
+	for i, t := range selectedTenants() {
+		if !startTenantTransfer(t.Name) {
+			for _, claimed := range selectedTenants()[:i] {
+				finishTenantTransfer(claimed.Name)
+			}
+			finishTransfer()
+			return fmt.Errorf("tenant %s: %w", t.Name, errTransferInProgress)
+		}
+	}
+	return nil
+}
 
-	// Connect to PostgreSQL
-	pgDb, err := sql.Open("postgres", pgDsn)
+// finishOnce releases what startOnce claimed.
+func finishOnce() {
+	for _, t := range selectedTenants() {
+		finishTenantTransfer(t.Name)
+	}
+	finishTransfer()
+}
+
+// runOnce is a one-off transfer of forDate, as run by -once, -date and POST
+// /transfer: every selected tenant in tenant mode, otherwise the top-level
+// flags' transfer. The caller claims the transfer with startOnce.
+func runOnce(ctx context.Context, forDate time.Time) error {
+	if len(tenants) > 0 {
+		return runTenantsOnce(ctx, forDate)
+	}
+	return runTransfer(ctx, forDate)
+}
+
+// runScheduledTransfer runs a transfer for forDate unless one is already in
+// progress.
+func runScheduledTransfer(ctx context.Context, forDate time.Time) {
+	if t := tenantFromContext(ctx); t != nil {
+		if !startTenantTransfer(t.Name) {
+			slog.WarnContext(ctx, "Skipping scheduled transfer: previous transfer still in progress", "date", forDate.Format("2006-01-02"))
+			return
+		}
+		defer finishTenantTransfer(t.Name)
+		_ = runTransfer(ctx, forDate)
+		return
+	}
+	if !startTransfer() {
+		slog.WarnContext(ctx, "Skipping scheduled transfer: previous transfer still in progress", "date", forDate.Format("2006-01-02"))
+		return
+	}
+	defer finishTransfer()
+	_ = runTransfer(ctx, forDate)
+}
+
+// resolveFlag returns flagVal, or the value of envVar when the flag was not
+// set on the command line or in the config file.
+func resolveFlag(flagVal, envVar string) string {
+	if flagVal != "" {
+		return flagVal
+	}
+	return os.Getenv(envVar)
+}
+
+// readDSNFile reads a DSN from path, such as a Docker or Kubernetes secret,
+// trimming surrounding whitespace.
+func readDSNFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+		return "", err
 	}
-	defer pgDb.Close()
+	dsn := strings.TrimSpace(string(data))
+	if dsn == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return dsn, nil
+}
 
-	// Connect to MySQL
-	sqlDb, err := sql.Open("mysql", mysqlDsn)
+// runInterval runs a transfer every d until ctx is cancelled. The transfer
+// date is taken from the tick, not from when the queries run, so a slow
+// transfer started just before midnight still reports the right day.
+func runInterval(ctx context.Context, d time.Duration, loc *time.Location) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	slog.Info("Running transfers on a fixed interval", "interval", d.String())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case tick := <-ticker.C:
+			runScheduledTransfer(ctx, tick.In(loc))
+		}
+	}
+}
+
+// backfillRange transfers every day from from to to inclusive, in order, with
+// the dates read in loc. A failing day is logged and the remaining days are
+// still attempted; the returned error lists the dates that failed.
+func backfillRange(ctx context.Context, from, to string, loc *time.Location) error {
+	if from == "" || to == "" {
+		return errors.New("fromDate and toDate must be provided together")
+	}
+	start, err := parseDate(from, loc)
 	if err != nil {
-		log.Fatalf("Failed to connect to MySQL: %v", err)
-	}
-	defer sqlDb.Close()
-
-	today := time.Now().Format("2006-01-02")
-
-	// 1. Active Machines Count ALEO
-	AleoActiveMachineCount := queryCount(pgDb, `SELECT count(*) FROM machine m WHERE to_timestamp(m.last_commit_solution) >= DATE(NOW()) AND project='ALEO'`)
-	insertToMySQL(sqlDb, "active_machines_count_aleo", today, AleoActiveMachineCount)
-
-	// 2. Active Machines Count QUAI
-	QuaiActiveMachineCount := queryCount(pgDb, `SELECT count(*) FROM machine m WHERE to_timestamp(m.last_commit_solution) >= DATE(NOW()) AND project='Quai'`)
-	insertToMySQL(sqlDb, "active_machines_count_quai", today, QuaiActiveMachineCount)
-
-	// 3. Lost Users Count
-	lostUsersQuery := `WITH machine_activity AS (
-		SELECT ma.main_user_id, MAX(m.last_commit_solution) AS max_last_commit_solution
-		FROM miner_account ma
-		JOIN machine m ON m.miner_account_id = ma.id
-		GROUP BY ma.main_user_id
-	)
-	SELECT COUNT(distinct u.email) FROM public."user" u
-	LEFT JOIN machine_activity ma ON ma.main_user_id = u.id
-	WHERE  to_timestamp(ma.max_last_commit_solution) < (DATE_TRUNC('day', NOW()) - INTERVAL '1 days')`
-	lostUsersCount := queryCount(pgDb, lostUsersQuery)
-	insertToMySQL(sqlDb, "lost_users_count", today, lostUsersCount)
-
-	// 3. Active Machines in Channel Aleo
-	AleoactiveMachinesChannelQuery := `WITH select_user AS(
-		SELECT u.email, ma.id, ma.name
-		FROM miner_account ma
-		LEFT JOIN "public"."user" u ON u.id = ma.main_user_id
-		LEFT JOIN invitation_code ic ON ic."id" = u.invitation_code_id
-		WHERE ic.tag in (
-			SELECT tag
-				FROM bonus_obj
-				WHERE user_id IS NULL 
-					AND project = 'ALEO' 
-					AND tag !='default'
-				)
-	)
-	SELECT count(*) FROM machine m 
-	JOIN select_user su ON m.miner_account_id = su.id
-	WHERE to_timestamp(m.last_commit_solution) >= DATE(NOW())`
-	AleoActiveMachinesChannelCount := queryCount(pgDb, AleoactiveMachinesChannelQuery)
-	insertToMySQL(sqlDb, "active_channel_machines_count_aleo", today, AleoActiveMachinesChannelCount)
-
-	// 3.2. Active Machines in Channel Quai
-	QuaiActiveMachinesChannelQuery := `WITH select_user AS(
-		SELECT u.email, ma.id, ma.name
-		FROM miner_account ma
-		LEFT JOIN "public"."user" u ON u.id = ma.main_user_id
-		LEFT JOIN invitation_code ic ON ic."id" = u.invitation_code_id
-		WHERE ic.tag in (
-			SELECT tag
-				FROM bonus_obj
-				WHERE user_id IS NULL 
-					AND project = 'Quai' 
-					AND tag !='default'
-				)
-	)
-	SELECT count(*) FROM machine m 
-	JOIN select_user su ON m.miner_account_id = su.id
-	WHERE to_timestamp(m.last_commit_solution) >= DATE(NOW())`
-	QuaiActiveMachinesChannelCount := queryCount(pgDb, QuaiActiveMachinesChannelQuery)
-	insertToMySQL(sqlDb, "active_channel_machines_count_quai", today, QuaiActiveMachinesChannelCount)
-
-	log.Println("Data transfer completed.")
-}
-
-func queryCount(db *sql.DB, query string) int {
-	var count int
-	err := db.QueryRow(query).Scan(&count)
+		return err
+	}
+	end, err := parseDate(to, loc)
 	if err != nil {
-		log.Printf("Failed to execute query: %s, error: %v", query, err)
-		os.Exit(1)
+		return err
+	}
+	if end.Before(start) {
+		return fmt.Errorf("toDate %s is before fromDate %s", to, from)
 	}
-	return count
+
+	var succeeded int
+	var failed []string
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if ctx.Err() != nil {
+			slog.Warn("Backfill interrupted", "date", d.Format("2006-01-02"))
+			failed = append(failed, d.Format("2006-01-02"))
+			continue
+		}
+		if err := runTransfer(ctx, d); err != nil {
+			failed = append(failed, d.Format("2006-01-02"))
+			continue
+		}
+		succeeded++
+	}
+
+	slog.Info("Backfill summary", "succeeded", succeeded, "failed", len(failed), "failed_dates", failed)
+	if len(failed) > 0 {
+		return fmt.Errorf("backfill failed for dates: %s", strings.Join(failed, ", "))
+	}
+	return nil
 }
 
-func insertToMySQL(db *sql.DB, tableName, date string, count int) {
-	query := fmt.Sprintf("INSERT INTO %s (date, count) VALUES (?, ?)", tableName)
-	_, err := db.Exec(query, date, count)
+// runTransfer runs transferData for forDate, logs its summary line and
+// records the outcome for /status.
+func runTransfer(ctx context.Context, forDate time.Time) error {
+	// A simulated run never touches PostgreSQL.
+	var sources []string
+	if !*simulate {
+		sources = pgSources()
+	}
+	// With a file output, -s3Only or -kafkaOnly the results bypass MySQL.
+	var targets []string
+	if !fileOutputEnabled() && !*s3Only && !*kafkaOnly {
+		targets = mysqlTargets()
+	}
+	// A tenant brings its own databases.
+	if t := tenantFromContext(ctx); t != nil {
+		sources, targets = []string{t.PgDsn}, []string{t.MysqlDsn}
+	}
+	res, err := transferData(ctx, sources, targets, forDate)
+	lastTransfer.record(res, err)
+	// Notifications must go out even when the failure was a shutdown.
+	notifyCtx := context.WithoutCancel(ctx)
 	if err != nil {
-		log.Printf("Failed to insert data to MySQL: %s, error: %v", query, err)
-		os.Exit(1)
+		slog.ErrorContext(ctx, "Data transfer finished with errors", "result", res, "error", err)
+		alertTransferFailed(notifyCtx, res, err)
+		notifySlack(notifyCtx, res, err)
+		return err
 	}
-	log.Printf("Successfully inserted data into %s: date=%s, count=%d", tableName, date, count)
+	slog.InfoContext(ctx, "Data transfer completed", "result", res)
+	notifySlack(notifyCtx, res, nil)
+	return nil
 }
 
-/*
-MySQL Table Creation Statements
+// withQueryTimeout bounds a single database call by -queryTimeout; zero
+// disables the limit.
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return transfer.WithTimeout(ctx, *queryTimeout)
+}
+
+// mysqlWriter writes metric rows as configured by -upsert and -queryTimeout.
+func mysqlWriter() transfer.Writer {
+	return transfer.Writer{Upsert: *upsert, Timeout: *queryTimeout}
+}
+
+// renderDate replaces the legacy {{date}} placeholder in a query with forDate
+// as a PostgreSQL date literal. forDate always comes from time.Time
+// formatting, so the literal cannot carry anything but digits and dashes.
+// New queries should use $1 instead; see queryArgs.
+func renderDate(query string, forDate time.Time) string {
+	return strings.ReplaceAll(query, "{{date}}", "DATE '"+forDate.Format("2006-01-02")+"'")
+}
 
-CREATE TABLE active_machines_count (
-	date DATE NOT NULL,
-	count INT NOT NULL,
-	PRIMARY KEY (date)
-);
+// dateParam matches a $1 placeholder that is not part of a longer $1n.
+var dateParam = regexp.MustCompile(`\$1\b`)
 
-CREATE TABLE lost_users_count (
-	date DATE NOT NULL,
-	count INT NOT NULL,
-	PRIMARY KEY (date)
-);
+// queryArgs returns the arguments for query's placeholders: the transfer
+// date as YYYY-MM-DD for $1, which queries cast with $1::date. Queries
+// without $1 get no arguments, since PostgreSQL rejects unused parameters.
+func queryArgs(query string, forDate time.Time) []any {
+	if !dateParam.MatchString(query) {
+		return nil
+	}
+	return []any{forDate.Format("2006-01-02")}
+}
+
+// renderQuery replaces the {{schema}} placeholder in queryTemplate with schema
+// as a quoted identifier, {{activeWindowSeconds}} with -activeWindow in whole
+// seconds and {{lostUserDays}} with -lostUserDays. validateFlags has already
+// checked that schema is a plain identifier and that both numbers are in
+// range.
+func renderQuery(queryTemplate, schema string) string {
+	return strings.NewReplacer(
+		"{{schema}}", `"`+schema+`"`,
+		"{{activeWindowSeconds}}", strconv.FormatInt(int64(activeWindow.Seconds()), 10),
+		"{{lostUserDays}}", strconv.Itoa(*lostUserDays),
+	).Replace(queryTemplate)
+}
 
-CREATE TABLE active_channel_machines_count (
-	date DATE NOT NULL,
-	count INT NOT NULL,
-	PRIMARY KEY (date)
-);
-*/
+// parseDate parses a YYYY-MM-DD date as midnight in loc, the -timezone the
+// scheduler runs in, rejecting anything that is not a real calendar day or
+// lies in the future there.
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	d, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD: %w", s, err)
+	}
+	if d.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("invalid date %q: date is in the future", s)
+	}
+	return d, nil
+}
+
+// resolveTableName returns the MySQL table for a metric's base table name
+// from the metrics file.
+func resolveTableName(base, prefix, suffix string) string {
+	return prefix + base + suffix
+}
+
+// metricTable resolves base with -tablePrefix and -tableSuffix.
+func metricTable(base string) string {
+	return resolveTableName(base, *tablePrefix, *tableSuffix)
+}
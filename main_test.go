@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"oula-transfer/internal/transfer"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, so that
+// tests can check command-line handling in a subprocess.
+const runMainEnv = "OULA_TRANSFER_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	flag.Parse()
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	os.Exit(m.Run())
+}
+
+// setFlag sets the named flag for the duration of the test.
+func setFlag(t testing.TB, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag %s", name)
+	}
+	old := f.Value.String()
+	if err := f.Value.Set(value); err != nil {
+		t.Fatalf("-%s=%s: %v", name, value, err)
+	}
+	t.Cleanup(func() { f.Value.Set(old) })
+}
+
+func TestParseDate(t *testing.T) {
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+	tomorrow := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+
+	tests := []struct {
+		in      string
+		loc     *time.Location
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "2024-01-15", loc: time.UTC, want: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{in: "2024-02-29", loc: time.UTC, want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{in: "2024-01-15", loc: shanghai, want: time.Date(2024, 1, 15, 0, 0, 0, 0, shanghai)},
+		{in: "2023-02-29", loc: time.UTC, wantErr: true},
+		{in: "2024-13-01", loc: time.UTC, wantErr: true},
+		{in: "15-01-2024", loc: time.UTC, wantErr: true},
+		{in: "2024-1-5", loc: time.UTC, wantErr: true},
+		{in: "", loc: time.UTC, wantErr: true},
+		{in: tomorrow, loc: time.UTC, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in+" "+tt.loc.String(), func(t *testing.T) {
+			got, err := parseDate(tt.in, tt.loc)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseDate(%q) = %s, want error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDate(%q): %v", tt.in, err)
+			}
+			if !got.Equal(tt.want) || got.Location() != tt.loc {
+				t.Errorf("parseDate(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseDateTodayAhead checks that today in a zone ahead of UTC is not
+// rejected as a future date.
+func TestParseDateTodayAhead(t *testing.T) {
+	kiritimati := mustLoadLocation(t, "Pacific/Kiritimati")
+	today := time.Now().In(kiritimati).Format("2006-01-02")
+	got, err := parseDate(today, kiritimati)
+	if err != nil {
+		t.Fatalf("parseDate(%q) in %s: %v", today, kiritimati, err)
+	}
+	if got.Format("2006-01-02") != today {
+		t.Errorf("parseDate(%q) = %s, want the same day", today, got)
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	day := time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query string
+		want  []any
+	}{
+		{"SELECT count(*) FROM machine WHERE to_timestamp(last_commit_solution) >= $1::date", []any{"2024-01-15"}},
+		{"SELECT count(*) FROM u WHERE created_at::date = $1", []any{"2024-01-15"}},
+		{"SELECT count(*) FROM machine", nil},
+		{"SELECT $10 FROM t", nil},
+	}
+	for _, tt := range tests {
+		got := queryArgs(tt.query, day)
+		if len(got) != len(tt.want) || (len(got) == 1 && got[0] != tt.want[0]) {
+			t.Errorf("queryArgs(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRenderDate(t *testing.T) {
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	got := renderDate("SELECT count(*) FROM machine WHERE day = {{date}} OR day = {{date}} - 1", day)
+	want := "SELECT count(*) FROM machine WHERE day = DATE '2024-01-15' OR day = DATE '2024-01-15' - 1"
+	if got != want {
+		t.Errorf("renderDate = %q, want %q", got, want)
+	}
+}
+
+// TestDateParameterIssued checks that the transfer date reaches PostgreSQL as
+// the $1 argument rather than being spliced into the SQL.
+func TestDateParameterIssued(t *testing.T) {
+	const query = "SELECT count(*) FROM machine WHERE to_timestamp(last_commit_solution) >= $1::date"
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectQuery(query).WithArgs("2023-12-31").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+
+	day := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	rendered := renderDate(query, day)
+	if _, err := transfer.QueryScalar[int64](context.Background(), db, 0, rendered, queryArgs(rendered, day)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
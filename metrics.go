@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MetricQuery describes one PostgreSQL count query and the MySQL table its
+// result is written to.
+type MetricQuery struct {
+	Name       string `yaml:"name" json:"name"`
+	Query      string `yaml:"query" json:"query"`
+	MySQLTable string `yaml:"mysqlTable" json:"mysqlTable"`
+	// MinExpectedValue, when positive, is the smallest count considered
+	// healthy; anything lower is logged as an error and alerted on.
+	MinExpectedValue int `yaml:"minExpectedValue" json:"minExpectedValue"`
+	// Formula, used instead of Query, makes this a DerivedMetric computed
+	// from the other metrics of the run.
+	Formula string `yaml:"formula" json:"formula"`
+	// Scale is the number of decimal places a formula or float result is
+	// rounded to and stored with, 1 to 8. Zero means 2 for a formula and
+	// DECIMAL(20,8) for a float; a set scale stores floats as
+	// DECIMAL(10,scale).
+	Scale int `yaml:"scale" json:"scale"`
+	// ZeroOnDivideByZero stores 0 when the formula divides by zero, e.g. a
+	// ratio on a day without any machines, instead of skipping the metric.
+	ZeroOnDivideByZero bool `yaml:"zeroOnDivideByZero" json:"zeroOnDivideByZero"`
+	// Aggregate is what the query computes: count (the default), sum, min,
+	// max or avg. It decides the MySQL column type and how values from
+	// several -pgDsns sources are combined.
+	Aggregate string `yaml:"aggregate" json:"aggregate"`
+	// Type is the value type: int (the default), float or string. Float
+	// metrics are stored in a DECIMAL value column sized by Scale, strings
+	// in a VARCHAR(255) one.
+	Type string `yaml:"type" json:"type"`
+	// Upsert overwrites an existing row for the date even without -upsert,
+	// for snapshot metrics whose value for a day may be refreshed.
+	Upsert bool `yaml:"upsert" json:"upsert"`
+	// Projects, when set, turns the metric into a template: it is run once
+	// per project with {{project}} replaced, and each project's suffix is
+	// appended to the name and MySQL table.
+	Projects []ProjectMetric `yaml:"projects" json:"projects"`
+	// Project is the project an expanded template metric was generated
+	// for; it is not read from the file.
+	Project string `yaml:"-" json:"-"`
+}
+
+// ProjectMetric is one project a templated metric is run for.
+type ProjectMetric struct {
+	Project          string `yaml:"project" json:"project"`
+	MySQLTableSuffix string `yaml:"mysqlTableSuffix" json:"mysqlTableSuffix"`
+	// QueryTemplate overrides the metric's query for this project.
+	QueryTemplate string `yaml:"queryTemplate" json:"queryTemplate"`
+}
+
+var projectPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+var tableNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// loadMetricQueries reads metric definitions from path, or returns the
+// built-in set from metricRegistry when path is empty.
+func loadMetricQueries(path string) ([]MetricQuery, error) {
+	if path == "" {
+		metrics := metricRegistry.Metrics()
+		if err := validateMetricQueries(metrics); err != nil {
+			return nil, err
+		}
+		return metrics, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics file: %w", err)
+	}
+	isJSON := strings.EqualFold(filepath.Ext(path), ".json")
+
+	var metrics []MetricQuery
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&metrics)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&metrics)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics file %s: %w", path, err)
+	}
+	if metrics, err = expandProjectMetrics(metrics); err != nil {
+		return nil, err
+	}
+	if err := validateMetricQueries(metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// expandProjectMetrics replaces every metric that lists projects with one
+// metric per project. The project name ends up inside a SQL string literal,
+// so it is restricted to letters, digits and underscores.
+func expandProjectMetrics(metrics []MetricQuery) ([]MetricQuery, error) {
+	var out []MetricQuery
+	for _, m := range metrics {
+		if len(m.Projects) == 0 {
+			out = append(out, m)
+			continue
+		}
+		for _, p := range m.Projects {
+			if !projectPattern.MatchString(p.Project) {
+				return nil, fmt.Errorf("metric %s: project %q must match %s", m.Name, p.Project, projectPattern)
+			}
+			suffix := p.MySQLTableSuffix
+			if suffix == "" {
+				suffix = strings.ToLower(p.Project)
+			}
+			query := m.Query
+			if p.QueryTemplate != "" {
+				query = p.QueryTemplate
+			}
+			out = append(out, MetricQuery{
+				Name:             m.Name + "_" + suffix,
+				Query:            strings.ReplaceAll(query, "{{project}}", p.Project),
+				MySQLTable:       m.MySQLTable + "_" + suffix,
+				MinExpectedValue: m.MinExpectedValue,
+				Aggregate:        m.Aggregate,
+				Type:             m.Type,
+				Upsert:           m.Upsert,
+				Project:          p.Project,
+			})
+		}
+	}
+	return out, nil
+}
+
+// loadQueriesDir reads every <metric_name>.sql file in dir as a metric whose
+// MySQL table has the same name. Files are returned in name order.
+func loadQueriesDir(dir string) ([]MetricQuery, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queries directory: %w", err)
+	}
+	var metrics []MetricQuery
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".sql") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read query file: %w", err)
+		}
+		name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if err := checkSQL(string(data)); err != nil {
+			return nil, fmt.Errorf("query file %s: %w", e.Name(), err)
+		}
+		metrics = append(metrics, MetricQuery{Name: name, Query: string(data), MySQLTable: name})
+	}
+	if err := validateMetricQueries(metrics); err != nil {
+		return nil, fmt.Errorf("queries directory %s: %w", dir, err)
+	}
+	return metrics, nil
+}
+
+// checkSQL catches the obvious editing mistakes in a query file: an empty
+// file, an unterminated quote or unbalanced parentheses. Everything else is
+// left to PostgreSQL.
+func checkSQL(query string) error {
+	if strings.TrimSpace(query) == "" {
+		return errors.New("query is empty")
+	}
+	depth := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			if depth--; depth < 0 {
+				return errors.New("unbalanced parentheses")
+			}
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("unterminated %c quote", quote)
+	}
+	if depth != 0 {
+		return errors.New("unbalanced parentheses")
+	}
+	return nil
+}
+
+func validateMetricQueries(metrics []MetricQuery) error {
+	if len(metrics) == 0 {
+		return errors.New("no metrics defined")
+	}
+	seen := make(map[string]bool)
+	for i, m := range metrics {
+		if strings.TrimSpace(m.Name) == "" {
+			return fmt.Errorf("metric #%d: name must not be empty", i+1)
+		}
+		if seen[m.Name] {
+			return fmt.Errorf("metric %s: defined more than once", m.Name)
+		}
+		seen[m.Name] = true
+		if strings.TrimSpace(m.Query) == "" && strings.TrimSpace(m.Formula) == "" {
+			return fmt.Errorf("metric %s: query must not be empty", m.Name)
+		}
+		if !validAggregate(m.Aggregate) {
+			return fmt.Errorf("metric %s: aggregate %q must be one of count, sum, avg, min or max", m.Name, m.Aggregate)
+		}
+		if !validType(m.Type) {
+			return fmt.Errorf("metric %s: type %q must be int, float or string", m.Name, m.Type)
+		}
+		if m.Query != "" && m.Formula != "" {
+			return fmt.Errorf("metric %s: query and formula are mutually exclusive", m.Name)
+		}
+		if m.Formula == "" && m.ZeroOnDivideByZero {
+			return fmt.Errorf("metric %s: zeroOnDivideByZero only applies to formula metrics", m.Name)
+		}
+		if m.Formula == "" && !m.isFloat() && m.Scale != 0 {
+			return fmt.Errorf("metric %s: scale only applies to formula and float metrics", m.Name)
+		}
+		if m.Scale < 0 || m.Scale > maxDerivedScale {
+			return fmt.Errorf("metric %s: scale must be between 1 and %d, got %d", m.Name, maxDerivedScale, m.Scale)
+		}
+		if m.MinExpectedValue < 0 {
+			return fmt.Errorf("metric %s: minExpectedValue must not be negative", m.Name)
+		}
+		if !tableNamePattern.MatchString(m.MySQLTable) {
+			return fmt.Errorf("metric %s: mysqlTable %q must match %s", m.Name, m.MySQLTable, tableNamePattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+func init() {
+	metricRegistry.Register(MetricDef{
+		Name:       "active_machines_count_aleo",
+		Query:      activeMachinesQuery("ALEO"),
+		MySQLTable: "active_machines_count_aleo",
+		Project:    "ALEO",
+	})
+	metricRegistry.Register(MetricDef{
+		Name:       "active_channel_machines_count_aleo",
+		Query:      activeChannelMachinesQuery("ALEO"),
+		MySQLTable: "active_channel_machines_count_aleo",
+		Project:    "ALEO",
+	})
+	// Channel penetration: the share of active machines that came through
+	// a channel. A day without active machines stores 0.
+	metricRegistry.Register(MetricDef{
+		Name:               "channel_machines_fraction_aleo",
+		Formula:            "active_channel_machines_count_aleo / active_machines_count_aleo",
+		MySQLTable:         "channel_machines_fraction_aleo",
+		Scale:              4,
+		ZeroOnDivideByZero: true,
+	})
+}
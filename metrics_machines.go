@@ -0,0 +1,68 @@
+package main
+
+// machinesPerUserQuery computes expr over the number of active machines of
+// each user with at least one active machine on the transfer date.
+func machinesPerUserQuery(expr string) string {
+	return `SELECT COALESCE(` + expr + `, 0) FROM (
+    SELECT ma.main_user_id, COUNT(*) AS machine_count
+    FROM {{schema}}.machine m
+    JOIN {{schema}}.miner_account ma ON m.miner_account_id = ma.id
+    WHERE to_timestamp(m.last_commit_solution) >= $1::date
+    GROUP BY ma.main_user_id
+) sub`
+}
+
+func init() {
+	// Averages and percentiles cannot be combined across several
+	// -pgDsns sources, so all three are marked avg; with more than one
+	// source they are left out of the built-in set.
+	metricRegistry.Register(MetricDef{
+		Name:       "avg_machines_per_user",
+		Query:      machinesPerUserQuery("AVG(machine_count)"),
+		MySQLTable: "avg_machines_per_user",
+		Aggregate:  aggregateAvg,
+		Type:       typeFloat,
+		Scale:      2,
+	})
+	metricRegistry.Register(MetricDef{
+		Name:       "p50_machines_per_user",
+		Query:      machinesPerUserQuery("PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY machine_count)"),
+		MySQLTable: "p50_machines_per_user",
+		Aggregate:  aggregateAvg,
+		Type:       typeFloat,
+		Scale:      2,
+	})
+	metricRegistry.Register(MetricDef{
+		Name:       "p95_machines_per_user",
+		Query:      machinesPerUserQuery("PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY machine_count)"),
+		MySQLTable: "p95_machines_per_user",
+		Aggregate:  aggregateAvg,
+		Type:       typeFloat,
+		Scale:      2,
+	})
+
+	// All active machines regardless of project. It is queried rather than
+	// summed from the per-project metrics, so a machine listed under
+	// several projects is only counted once.
+	metricRegistry.Register(MetricDef{
+		Name:       "active_machines_count_total",
+		Query:      `SELECT count(*) FROM {{schema}}.machine m WHERE to_timestamp(m.last_commit_solution) >= $1::date`,
+		MySQLTable: "active_machines_count_total",
+	})
+
+	// Machines that committed within -activeWindow of the time the query
+	// runs, a real-time view of pool health. It ignores the transfer date,
+	// so backfilled days get the value as of the backfill.
+	metricRegistry.Register(MetricDef{
+		Name:       "recently_active_machines_count",
+		Query:      `SELECT count(*) FROM {{schema}}.machine m WHERE to_timestamp(m.last_commit_solution) >= NOW() - make_interval(secs => {{activeWindowSeconds}})`,
+		MySQLTable: "recently_active_machines_count",
+	})
+
+	// Projects with at least one active machine on the transfer date.
+	metricRegistry.Register(MetricDef{
+		Name:       "active_projects_count",
+		Query:      `SELECT COUNT(DISTINCT project) FROM {{schema}}.machine m WHERE to_timestamp(m.last_commit_solution) >= $1::date`,
+		MySQLTable: "active_projects_count",
+	})
+}
@@ -0,0 +1,16 @@
+package main
+
+func init() {
+	metricRegistry.Register(MetricDef{
+		Name:       "active_machines_count_quai",
+		Query:      activeMachinesQuery("Quai"),
+		MySQLTable: "active_machines_count_quai",
+		Project:    "Quai",
+	})
+	metricRegistry.Register(MetricDef{
+		Name:       "active_channel_machines_count_quai",
+		Query:      activeChannelMachinesQuery("Quai"),
+		MySQLTable: "active_channel_machines_count_quai",
+		Project:    "Quai",
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+func init() {
+	// Users whose most recent machine activity is more than -lostUserDays
+	// days before the transfer date.
+	metricRegistry.Register(MetricDef{
+		Name: "lost_users_count",
+		Query: `WITH machine_activity AS (
+    SELECT ma.main_user_id, MAX(m.last_commit_solution) AS max_last_commit_solution
+    FROM {{schema}}.miner_account ma
+    JOIN {{schema}}.machine m ON m.miner_account_id = ma.id
+    GROUP BY ma.main_user_id
+)
+SELECT COUNT(distinct u.email) FROM {{schema}}."user" u
+LEFT JOIN machine_activity ma ON ma.main_user_id = u.id
+WHERE  to_timestamp(ma.max_last_commit_solution) < ($1::date - INTERVAL '{{lostUserDays}} days')`,
+		MySQLTable: "lost_users_count",
+	})
+
+	// Users who registered on the transfer date; with lost_users_count it
+	// gives the net user growth.
+	metricRegistry.Register(MetricDef{
+		Name:       "new_users_count",
+		Query:      `SELECT COUNT(*) FROM {{schema}}."user" WHERE created_at::date = $1::date`,
+		MySQLTable: "new_users_count",
+	})
+
+	// All users registered up to the transfer date. It is a snapshot, so a
+	// re-run for the day overwrites the stored total.
+	metricRegistry.Register(MetricDef{
+		Name:       "total_users_count",
+		Query:      `SELECT COUNT(*) FROM {{schema}}."user" WHERE created_at::date <= $1::date`,
+		MySQLTable: "total_users_count",
+		Upsert:     true,
+	})
+
+	// Miner accounts that never had a machine attached: sign-ups that
+	// dropped off before connecting their first machine.
+	metricRegistry.Register(MetricDef{
+		Name:       "dormant_accounts_count",
+		Query:      `SELECT COUNT(*) FROM {{schema}}.miner_account ma WHERE NOT EXISTS (SELECT 1 FROM {{schema}}.machine m WHERE m.miner_account_id = ma.id)`,
+		MySQLTable: "dormant_accounts_count",
+	})
+}
@@ -0,0 +1,7 @@
+-- One row per day for a derived metric. {{table}} is replaced with the
+-- metric's mysqlTable and {{scale}} with its decimal places (default 2).
+CREATE TABLE IF NOT EXISTS {{table}} (
+	date DATE NOT NULL,
+	value DECIMAL(10,{{scale}}) NOT NULL,
+	PRIMARY KEY (date)
+);
@@ -0,0 +1,8 @@
+-- One row per day for a float or avg metric. {{table}} is replaced with
+-- the metric's mysqlTable; {{precision}} and {{scale}} are 10 and its
+-- scale when one is set, 20 and 8 otherwise.
+CREATE TABLE IF NOT EXISTS {{table}} (
+	date DATE NOT NULL,
+	value DECIMAL({{precision}},{{scale}}) NOT NULL,
+	PRIMARY KEY (date)
+);
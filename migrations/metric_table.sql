@@ -0,0 +1,7 @@
+-- One row per day for a metric. {{table}} is replaced with the metric's
+-- mysqlTable.
+CREATE TABLE IF NOT EXISTS {{table}} (
+	date DATE NOT NULL,
+	count INT NOT NULL,
+	PRIMARY KEY (date)
+);
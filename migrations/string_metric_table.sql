@@ -0,0 +1,7 @@
+-- One row per day for a string metric, such as the top miner's name.
+-- {{table}} is replaced with the metric's mysqlTable.
+CREATE TABLE IF NOT EXISTS {{table}} (
+	date DATE NOT NULL,
+	value VARCHAR(255) NOT NULL,
+	PRIMARY KEY (date)
+);
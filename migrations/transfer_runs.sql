@@ -0,0 +1,14 @@
+-- Audit trail of transfer runs, written by insertTransferRun and
+-- updateTransferRun.
+CREATE TABLE IF NOT EXISTS transfer_runs (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	transfer_date DATE NOT NULL,
+	started_at DATETIME NOT NULL,
+	completed_at DATETIME NULL,
+	status ENUM('running', 'success', 'partial', 'failure') NOT NULL,
+	metrics_succeeded INT NOT NULL DEFAULT 0,
+	metrics_failed INT NOT NULL DEFAULT 0,
+	error_message TEXT NULL,
+	PRIMARY KEY (id),
+	KEY idx_transfer_runs_date (transfer_date, status)
+);
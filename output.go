@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"oula-transfer/internal/transfer"
+)
+
+// metricRow is one metric value as written to the file outputs.
+type metricRow struct {
+	Date       string `json:"date"`
+	MetricName string `json:"metric"`
+	// Count is an int for count metrics and the stored value otherwise.
+	Count any `json:"count"`
+}
+
+func metricRows(date string, results []transfer.Result) []metricRow {
+	rows := make([]metricRow, 0, len(results))
+	for _, r := range results {
+		rows = append(rows, metricRow{Date: date, MetricName: r.Name, Count: r.Stored()})
+	}
+	return rows
+}
+
+// writeCSV appends rows to the CSV file at path, writing the
+// date,metric_name,count header first when the file is new or empty.
+func writeCSV(path string, rows []metricRow) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV output: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat CSV output: %w", err)
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		if err := w.Write([]string{"date", "metric_name", "count"}); err != nil {
+			return fmt.Errorf("failed to write CSV output: %w", err)
+		}
+	}
+	for _, r := range rows {
+		if err := w.Write([]string{r.Date, r.MetricName, fmt.Sprint(r.Count)}); err != nil {
+			return fmt.Errorf("failed to write CSV output: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV output: %w", err)
+	}
+	return f.Close()
+}
+
+// writeJSON merges rows into the JSON array stored at path, replacing any
+// existing entry with the same date and metric, and rewrites the file
+// atomically.
+func writeJSON(path string, rows []metricRow, pretty bool) error {
+	var existing []metricRow
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("failed to read JSON output: %w", err)
+	case len(data) > 0:
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return fmt.Errorf("failed to parse existing JSON output %s: %w", path, err)
+		}
+	}
+
+	type key struct{ date, metric string }
+	index := make(map[key]int, len(existing))
+	for i, r := range existing {
+		index[key{r.Date, r.MetricName}] = i
+	}
+	for _, r := range rows {
+		if i, ok := index[key{r.Date, r.MetricName}]; ok {
+			existing[i] = r
+			continue
+		}
+		index[key{r.Date, r.MetricName}] = len(existing)
+		existing = append(existing, r)
+	}
+
+	if pretty {
+		data, err = json.MarshalIndent(existing, "", "  ")
+	} else {
+		data, err = json.Marshal(existing)
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// writeFileAtomic replaces path with data via a temporary file in the same
+// directory, so readers never see a half-written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fileOutputEnabled reports whether results go to -csvOutput/-jsonOutput
+// instead of MySQL.
+func fileOutputEnabled() bool {
+	return *csvOutput != "" || *jsonOutput != ""
+}
+
+// writeFileOutputs writes results to every configured file output.
+func writeFileOutputs(date string, results []transfer.Result) error {
+	rows := metricRows(date, results)
+	var errs []error
+	if *csvOutput != "" {
+		errs = append(errs, writeCSV(*csvOutput, rows))
+	}
+	if *jsonOutput != "" {
+		errs = append(errs, writeJSON(*jsonOutput, rows, *jsonPretty))
+	}
+	return errors.Join(errs...)
+}
+
+// printMetricJSON writes row to w as a single line of JSON, for -outputStdout.
+func printMetricJSON(w io.Writer, row metricRow) error {
+	line := struct {
+		Metric string `json:"metric"`
+		Date   string `json:"date"`
+		Count  any    `json:"count"`
+	}{row.MetricName, row.Date, row.Count}
+	return json.NewEncoder(w).Encode(line)
+}
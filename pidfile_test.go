@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// deadPID returns the PID of a process that has already exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestAcquirePIDFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string // PID file content; "" means no file
+		wantErr  bool
+	}{
+		{name: "no file"},
+		{name: "stale PID", existing: strconv.Itoa(deadPID(t))},
+		{name: "garbage", existing: "not a pid"},
+		{name: "empty file", existing: "\n"},
+		{name: "own PID", existing: strconv.Itoa(os.Getpid())},
+		{name: "live process", existing: strconv.Itoa(os.Getppid()), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "oula-transfer.pid")
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := acquirePIDFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("acquirePIDFile succeeded, want error")
+				}
+				data, _ := os.ReadFile(path)
+				if string(data) != tt.existing {
+					t.Errorf("PID file = %q, want it left as %q", data, tt.existing)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("acquirePIDFile: %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(data)); got != strconv.Itoa(os.Getpid()) {
+				t.Errorf("PID file = %q, want %d", got, os.Getpid())
+			}
+		})
+	}
+}
+
+func TestProcessRunning(t *testing.T) {
+	if !processRunning(os.Getpid()) {
+		t.Error("processRunning(self) = false")
+	}
+	if processRunning(deadPID(t)) {
+		t.Error("processRunning(exited process) = true")
+	}
+	if processRunning(0) || processRunning(-1) {
+		t.Error("processRunning accepted a non-positive PID")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+const pingOnlyTimeout = 5 * time.Second
+
+// runPingOnly checks that both databases of every target are reachable and
+// logs their server versions. It returns an error if any check fails.
+func runPingOnly(ctx context.Context) error {
+	var errs []error
+	for _, target := range transferTargets() {
+		for _, dsn := range target.pgDsns {
+			pgDb, err := openPostgres(dsn, pgTLSFromFlags())
+			if err == nil {
+				defer pgDb.Close()
+				err = pingAndReportVersion(ctx, pgDb, "PostgreSQL")
+			}
+			if err != nil {
+				errs = append(errs, target.wrap(redact(err)))
+			}
+		}
+
+		for _, dsn := range target.mysqlDsns {
+			sqlDb, err := openMySQL(dsn, mysqlTLSFromFlags())
+			if err == nil {
+				defer sqlDb.Close()
+				err = pingAndReportVersion(ctx, sqlDb, "MySQL")
+			}
+			if err != nil {
+				errs = append(errs, target.wrap(redact(err)))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func pingAndReportVersion(ctx context.Context, db *sql.DB, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, pingOnlyTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		err = fmt.Errorf("failed to ping %s: %w", name, transfer.ContextError(ctx, err))
+		slog.Error("Ping failed", "database", name, "error", redact(err))
+		return err
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT version()").Scan(&version); err != nil {
+		err = fmt.Errorf("failed to query %s version: %w", name, transfer.ContextError(ctx, err))
+		slog.Error("Ping failed", "database", name, "error", redact(err))
+		return err
+	}
+	slog.Info("Ping succeeded", "database", name, "version", version)
+	return nil
+}
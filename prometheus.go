@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"oula-transfer/internal/transfer"
+)
+
+var (
+	lastSuccessTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "oula_transfer_last_success_timestamp_seconds",
+		Help: "Unix time of the last transfer that completed without errors.",
+	})
+	transferDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "oula_transfer_duration_seconds",
+		Help:    "Duration of transfer runs.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	})
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oula_transfer_query_errors_total",
+		Help: "Number of failed metric queries or inserts, by metric name.",
+	}, []string{"metric"})
+	rowsTransferred = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oula_transfer_rows_transferred_total",
+		Help: "Number of metric rows written to MySQL.",
+	})
+)
+
+// serveHTTP runs srv until ctx is cancelled, then shuts it down. It is meant
+// to be started in its own goroutine.
+func serveHTTP(ctx context.Context, srv *http.Server, name string) {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down HTTP server", "server", name, "error", err)
+		}
+	}()
+
+	slog.Info("Starting HTTP server", "server", name, "addr", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("HTTP server failed", "server", name, "error", err)
+	}
+}
+
+// startMetricsServer exposes the Prometheus /metrics endpoint on addr. An
+// empty addr disables it.
+func startMetricsServer(ctx context.Context, addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go serveHTTP(ctx, &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}, "metrics")
+}
+
+// pushMetrics pushes the outcome of one transfer to the Pushgateway at url,
+// for runs (such as Kubernetes Jobs with -once) that exit before a scrape.
+// The series are grouped by the local hostname.
+func pushMetrics(ctx context.Context, url string, res transfer.Summary, err error) error {
+	lastRun := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "oula_transfer_last_run_timestamp",
+		Help: "Unix time at which the last transfer run completed.",
+	})
+	lastRun.Set(float64(res.CompletedAt.Unix()))
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "oula_transfer_success",
+		Help: "1 if the last transfer run completed without errors, 0 otherwise.",
+	})
+	if err == nil {
+		success.Set(1)
+	}
+	counts := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "oula_transfer_metric_count",
+		Help: "Value transferred for each metric by the last run.",
+	}, []string{"metric"})
+	for name, count := range res.Counts {
+		counts.WithLabelValues(name).Set(float64(count))
+	}
+
+	host, hostErr := os.Hostname()
+	if hostErr != nil {
+		host = "unknown"
+	}
+	return push.New(url, "oula_transfer").
+		Grouping("instance", host).
+		Collector(lastRun).
+		Collector(success).
+		Collector(counts).
+		PushContext(ctx)
+}
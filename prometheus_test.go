@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"oula-transfer/internal/transfer"
+)
+
+// pushgateway records the last push it received.
+type pushgateway struct {
+	method   string
+	path     string
+	families map[string]*dto.MetricFamily
+	status   int
+}
+
+func (p *pushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.method, p.path = r.Method, r.URL.Path
+	p.families = make(map[string]*dto.MetricFamily)
+	dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+	for {
+		var mf dto.MetricFamily
+		if err := dec.Decode(&mf); err != nil {
+			if !errors.Is(err, io.EOF) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			break
+		}
+		p.families[mf.GetName()] = &mf
+	}
+	w.WriteHeader(p.status)
+}
+
+func (p *pushgateway) gauge(t *testing.T, name string, labels ...string) float64 {
+	t.Helper()
+	mf, ok := p.families[name]
+	if !ok {
+		t.Fatalf("metric %s was not pushed", name)
+	}
+	for _, m := range mf.GetMetric() {
+		if hasLabels(m, labels) {
+			return m.GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("metric %s%v was not pushed", name, labels)
+	return 0
+}
+
+// hasLabels reports whether m carries every name/value pair in labels.
+func hasLabels(m *dto.Metric, labels []string) bool {
+	have := make(map[string]string, len(m.GetLabel()))
+	for _, lp := range m.GetLabel() {
+		have[lp.GetName()] = lp.GetValue()
+	}
+	for i := 0; i+1 < len(labels); i += 2 {
+		if have[labels[i]] != labels[i+1] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPushMetrics(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip("no hostname")
+	}
+	completed := time.Date(2024, 1, 15, 23, 0, 5, 0, time.UTC)
+	res := transfer.Summary{
+		Date:        "2024-01-15",
+		CompletedAt: completed,
+		Counts:      map[string]int{"active_machines_count_aleo": 42, "lost_users_count": 3},
+	}
+
+	tests := []struct {
+		name        string
+		err         error
+		wantSuccess float64
+	}{
+		{"success", nil, 1},
+		{"failure", errors.New("lost_users_count: query timed out"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := &pushgateway{status: http.StatusOK}
+			srv := httptest.NewServer(gw)
+			defer srv.Close()
+
+			if err := pushMetrics(context.Background(), srv.URL, res, tt.err); err != nil {
+				t.Fatalf("pushMetrics: %v", err)
+			}
+			if gw.method != http.MethodPut {
+				t.Errorf("method = %s, want PUT", gw.method)
+			}
+			if want := "/metrics/job/oula_transfer/instance/" + host; gw.path != want {
+				t.Errorf("path = %s, want %s", gw.path, want)
+			}
+			if got := gw.gauge(t, "oula_transfer_success"); got != tt.wantSuccess {
+				t.Errorf("oula_transfer_success = %v, want %v", got, tt.wantSuccess)
+			}
+			if got := gw.gauge(t, "oula_transfer_last_run_timestamp"); got != float64(completed.Unix()) {
+				t.Errorf("oula_transfer_last_run_timestamp = %v, want %d", got, completed.Unix())
+			}
+			if got := gw.gauge(t, "oula_transfer_metric_count", "metric", "active_machines_count_aleo"); got != 42 {
+				t.Errorf("oula_transfer_metric_count{metric=active_machines_count_aleo} = %v, want 42", got)
+			}
+			if got := gw.gauge(t, "oula_transfer_metric_count", "metric", "lost_users_count"); got != 3 {
+				t.Errorf("oula_transfer_metric_count{metric=lost_users_count} = %v, want 3", got)
+			}
+		})
+	}
+}
+
+func TestPushMetricsRejected(t *testing.T) {
+	gw := &pushgateway{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+	if err := pushMetrics(context.Background(), srv.URL, transfer.Summary{}, nil); err == nil {
+		t.Fatal("pushMetrics succeeded against a failing Pushgateway")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"oula-transfer/internal/transfer"
+)
+
+const (
+	// redisDatesKey is a sorted set of every transferred date, scored by
+	// the date's Unix time so ZRANGEBYSCORE works on date ranges.
+	redisDatesKey = "oula:metrics:dates"
+	// redisMetricsTTL is how long a day's metrics hash is kept.
+	redisMetricsTTL = 30 * 24 * time.Hour
+)
+
+// redisMetricsKey is the hash holding one field per metric for date.
+func redisMetricsKey(date string) string {
+	return "oula:metrics:" + date
+}
+
+func newRedisClient() *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: *redisAddr, Password: *redisPassword})
+}
+
+// writeToRedis stores rows as HSET oula:metrics:<date> <metric_name> <count>
+// and adds each date to oula:metrics:dates. Everything is sent in one
+// MULTI/EXEC pipeline, so readers never see half a day.
+func writeToRedis(ctx context.Context, client *redis.Client, rows []metricRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	fields := make(map[string]map[string]any)
+	for _, row := range rows {
+		if fields[row.Date] == nil {
+			fields[row.Date] = make(map[string]any)
+		}
+		fields[row.Date][row.MetricName] = row.Count
+	}
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for date, values := range fields {
+			day, err := time.Parse("2006-01-02", date)
+			if err != nil {
+				return fmt.Errorf("invalid date %q: %w", date, err)
+			}
+			key := redisMetricsKey(date)
+			pipe.HSet(ctx, key, values)
+			pipe.Expire(ctx, key, redisMetricsTTL)
+			pipe.ZAdd(ctx, redisDatesKey, redis.Z{Score: float64(day.Unix()), Member: date})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write metrics to Redis: %w", transfer.ContextError(ctx, err))
+	}
+	slog.Info("Wrote metrics to Redis", "addr", *redisAddr, "rows", len(rows))
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+// MetricDef is a metric as registered from Go; it has the same fields as
+// an entry of a -metricsFile.
+type MetricDef = MetricQuery
+
+// MetricRegistry collects metric definitions. The built-in metrics register
+// themselves into metricRegistry from init functions in the metrics_*.go
+// files, so a new metric only needs a new file.
+type MetricRegistry struct {
+	mu   sync.Mutex
+	defs []MetricDef
+}
+
+// metricRegistry holds the built-in metrics used when no -metricsFile or
+// -queriesDir is given.
+var metricRegistry = &MetricRegistry{}
+
+// Register adds m. Like database/sql's Register it panics on a duplicate
+// name, since that is a programming error in the built-in set.
+func (r *MetricRegistry) Register(m MetricDef) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.defs {
+		if d.Name == m.Name {
+			panic("metric registered twice: " + m.Name)
+		}
+	}
+	r.defs = append(r.defs, m)
+}
+
+// Metrics returns a copy of the registered definitions in registration
+// order.
+func (r *MetricRegistry) Metrics() []MetricDef {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]MetricDef(nil), r.defs...)
+}
+
+// RunAll queries every registered metric on pgDB for date, computes the
+// formula metrics and writes all values to mysqlDB in one transaction. It
+// is the embeddable core of a transfer, run with transfer.Run but without
+// retries across sources, anomaly checks, history or notifications; a
+// failing metric is skipped and reported in the returned error.
+func (r *MetricRegistry) RunAll(ctx context.Context, pgDB, mysqlDB *sql.DB, date time.Time) error {
+	queries, derived, err := splitDerivedMetrics(r.Metrics())
+	if err != nil {
+		return err
+	}
+	day := date.Format("2006-01-02")
+	metrics := make([]transfer.Metric, 0, len(queries))
+	for _, m := range queries {
+		m := m
+		metrics = append(metrics, transfer.Metric{Name: m.Name, Collect: func() (transfer.Result, error) {
+			query := renderDate(renderQuery(m.Query, *pgSchema), date)
+			count, value, err := queryMetricValue(ctx, []*sql.DB{pgDB}, m, query, queryArgs(query, date)...)
+			if err == nil && value == nil {
+				err = validateCount(count, m.Name)
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "Skipping metric", "metric_name", m.Name, "date", day, "error", redact(err))
+				return transfer.Result{}, fmt.Errorf("%s: %w", m.Name, err)
+			}
+			return transfer.Result{Name: m.Name, Table: metricTable(m.MySQLTable), Count: count, Value: value, Upsert: m.Upsert}, nil
+		}})
+	}
+	_, err = transfer.Run(ctx, day, metrics, transfer.Options{
+		Workers: *workers,
+		Prepare: func(_ context.Context, results []transfer.Result) ([]transfer.Result, []error) {
+			derivedResults, errs := computeDerived(derived, results)
+			return append(results, derivedResults...), errs
+		},
+		Write: func(ctx context.Context, results []transfer.Result) ([]error, error) {
+			return nil, mysqlWriter().InsertAll(ctx, mysqlDB, day, results)
+		},
+	})
+	return err
+}
+
+// activeMachinesQuery counts the project's machines that committed a
+// solution on or after the transfer date.
+func activeMachinesQuery(project string) string {
+	return `SELECT count(*) FROM {{schema}}.machine m WHERE to_timestamp(m.last_commit_solution) >= $1::date AND project='` + project + `'`
+}
+
+// activeChannelMachinesQuery counts the active machines of users invited
+// through one of the project's channel tags.
+func activeChannelMachinesQuery(project string) string {
+	return `WITH select_user AS(
+    SELECT u.email, ma.id, ma.name
+    FROM {{schema}}.miner_account ma
+    LEFT JOIN {{schema}}."user" u ON u.id = ma.main_user_id
+    LEFT JOIN {{schema}}.invitation_code ic ON ic."id" = u.invitation_code_id
+    WHERE ic.tag in (
+        SELECT tag
+            FROM {{schema}}.bonus_obj
+            WHERE user_id IS NULL
+                AND project = '` + project + `'
+                AND tag !='default'
+            )
+)
+SELECT count(*) FROM {{schema}}.machine m
+JOIN select_user su ON m.miner_account_id = su.id
+WHERE to_timestamp(m.last_commit_solution) >= $1::date`
+}
+
+// nameList collects the comma-separated metric names of -metrics and
+// -excludeMetrics; repeated flags (or a config list) append.
+type nameList []string
+
+func (l *nameList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *nameList) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*l = append(*l, name)
+		}
+	}
+	return nil
+}
+
+// filterMetrics narrows all to the names in include, or drops those in
+// exclude, keeping the original order. Giving both is an error, as is an
+// unknown name, so that a typo does not silently run or skip the wrong
+// metrics.
+func filterMetrics(all []MetricDef, include, exclude []string) ([]MetricDef, error) {
+	if len(include) > 0 && len(exclude) > 0 {
+		return nil, errors.New("metrics and excludeMetrics are mutually exclusive")
+	}
+	known := make(map[string]bool, len(all))
+	for _, m := range all {
+		known[m.Name] = true
+	}
+	listed := make(map[string]bool, len(include)+len(exclude))
+	for _, names := range [][]string{include, exclude} {
+		for _, name := range names {
+			if !known[name] {
+				return nil, fmt.Errorf("unknown metric %q", name)
+			}
+			listed[name] = true
+		}
+	}
+	if len(include) == 0 && len(exclude) == 0 {
+		return all, nil
+	}
+	var out []MetricDef
+	for _, m := range all {
+		switch {
+		case len(include) > 0 && listed[m.Name]:
+			out = append(out, m)
+		case len(exclude) > 0 && !listed[m.Name]:
+			out = append(out, m)
+		case len(exclude) > 0:
+			slog.Info(fmt.Sprintf("skipping metric [%s] (excluded by flag)", m.Name))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"oula-transfer/internal/transfer"
+)
+
+func TestMetricRegistryRunAll(t *testing.T) {
+	errQuery := errors.New("relation does not exist")
+	errInsert := errors.New("duplicate entry")
+
+	tests := []struct {
+		name      string
+		failA     bool
+		insertErr error
+		wantErr   []error
+	}{
+		{name: "written"},
+		{name: "failing query is skipped", failA: true, wantErr: []error{errQuery}},
+		{name: "insert failure", insertErr: errInsert, wantErr: []error{errInsert}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "workers", "1")
+			r := &MetricRegistry{}
+			r.Register(MetricDef{Name: "metric_a", Query: "SELECT count(*) FROM a", MySQLTable: "metric_a"})
+			r.Register(MetricDef{Name: "metric_b", Query: "SELECT count(*) FROM b", MySQLTable: "metric_b"})
+			r.Register(MetricDef{Name: "ratio", Formula: "metric_a / metric_b", MySQLTable: "ratio"})
+
+			pg, pgMock := newMockDB(t)
+			my, myMock := newMockDB(t)
+			if tt.failA {
+				pgMock.ExpectQuery("SELECT count(*) FROM a").WillReturnError(errQuery)
+			} else {
+				pgMock.ExpectQuery("SELECT count(*) FROM a").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+			}
+			pgMock.ExpectQuery("SELECT count(*) FROM b").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(6))
+
+			myMock.ExpectBegin()
+			if !tt.failA {
+				exec := myMock.ExpectExec(transfer.InsertStatement("metric_a", "count", false)).WithArgs("2024-01-15", 3)
+				if tt.insertErr != nil {
+					exec.WillReturnError(tt.insertErr)
+					myMock.ExpectRollback()
+				} else {
+					exec.WillReturnResult(sqlmock.NewResult(1, 1))
+				}
+			}
+			if tt.insertErr == nil {
+				myMock.ExpectExec(transfer.InsertStatement("metric_b", "count", false)).WithArgs("2024-01-15", 6).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				// Without metric_a the ratio cannot be computed.
+				if !tt.failA {
+					myMock.ExpectExec(transfer.InsertStatement("ratio", "value", false)).WithArgs("2024-01-15", 0.5).
+						WillReturnResult(sqlmock.NewResult(1, 1))
+				}
+				myMock.ExpectCommit()
+			}
+
+			err := r.RunAll(context.Background(), pg, my, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("RunAll() error = %v, want %v", err, want)
+				}
+			}
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("RunAll() error = %v", err)
+			}
+			for _, mock := range []sqlmock.Sqlmock{pgMock, myMock} {
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+// defaultReportTemplate renders the report as one Markdown table per
+// project.
+const defaultReportTemplate = `# Oula metrics for {{.Date}}
+{{range .Projects}}
+## {{.Name}}
+
+| Metric | Value | Previous day | Change |
+|---|---:|---:|---:|
+{{range .Metrics}}| {{.Name}} | {{.Value}} | {{if .HasPrevious}}{{.Previous}}{{else}}-{{end}} | {{if .Delta}}{{.Delta}}{{else}}-{{end}} |
+{{end}}{{end}}`
+
+// reportData is what report templates are executed with.
+type reportData struct {
+	Date     string
+	Projects []reportProject
+}
+
+// reportProject groups the metrics of one project; metrics that are not
+// per project are listed under "general".
+type reportProject struct {
+	Name    string
+	Metrics []reportMetric
+}
+
+type reportMetric struct {
+	Name        string
+	Value       any
+	Previous    any
+	HasPrevious bool
+	// Delta is the signed change from the previous day for count metrics,
+	// e.g. "+12", and empty when there is nothing to compare.
+	Delta string
+}
+
+// generateReport renders the report for the latest date in rows. Rows for
+// the day before that date are not listed themselves; they are the previous
+// values the deltas are computed from. An empty tmplPath selects the
+// built-in Markdown template.
+func generateReport(rows []metricRow, tmplPath string) (string, error) {
+	if len(rows) == 0 {
+		return "", errors.New("no metrics to report")
+	}
+	text := defaultReportTemplate
+	if tmplPath != "" {
+		data, err := os.ReadFile(tmplPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read report template: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("report").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid report template: %w", err)
+	}
+
+	date := rows[0].Date
+	for _, r := range rows {
+		if r.Date > date {
+			date = r.Date
+		}
+	}
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return "", fmt.Errorf("invalid report date %q: %w", date, err)
+	}
+	previousDate := day.AddDate(0, 0, -1).Format("2006-01-02")
+	previous := make(map[string]any)
+	for _, r := range rows {
+		if r.Date == previousDate {
+			previous[r.MetricName] = r.Count
+		}
+	}
+
+	byProject := make(map[string][]reportMetric)
+	for _, r := range rows {
+		if r.Date != date {
+			continue
+		}
+		m := reportMetric{Name: r.MetricName, Value: r.Count}
+		m.Previous, m.HasPrevious = previous[r.MetricName]
+		if now, ok := r.Count.(int); ok {
+			if prev, ok := m.Previous.(int); ok {
+				m.Delta = fmt.Sprintf("%+d", now-prev)
+			}
+		}
+		project := metricProject(r.MetricName)
+		if project == "" {
+			project = "general"
+		}
+		byProject[project] = append(byProject[project], m)
+	}
+	data := reportData{Date: date}
+	for name, metrics := range byProject {
+		sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+		data.Projects = append(data.Projects, reportProject{Name: name, Metrics: metrics})
+	}
+	sort.Slice(data.Projects, func(i, j int) bool { return data.Projects[i].Name < data.Projects[j].Name })
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render report: %w", err)
+	}
+	return b.String(), nil
+}
+
+// previousDayRows reads the count stored for the day before date for every
+// count metric in results. A metric without a previous row is left out.
+func previousDayRows(ctx context.Context, db *sql.DB, date string, results []transfer.Result) ([]metricRow, error) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, err
+	}
+	previous := day.AddDate(0, 0, -1).Format("2006-01-02")
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var rows []metricRow
+	for _, r := range results {
+		if r.Value != nil {
+			continue
+		}
+		var count int
+		err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count FROM %s WHERE date = ?", r.Table), previous).Scan(&count)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read previous value from %s: %w", r.Table, transfer.ContextError(ctx, err))
+		}
+		rows = append(rows, metricRow{Date: previous, MetricName: r.Name, Count: count})
+	}
+	return rows, nil
+}
+
+// reportTransfer prints the report for a successful transfer and, with
+// -reportWebhook, posts it as {"date": ..., "text": ...}. Reporting is best
+// effort and never fails the transfer.
+func reportTransfer(ctx context.Context, db *sql.DB, date string, results []transfer.Result) {
+	rows := metricRows(date, results)
+	if db != nil {
+		previous, err := previousDayRows(ctx, db, date, results)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to read previous-day values for the report", "date", date, "error", redact(err))
+		}
+		rows = append(rows, previous...)
+	}
+	report, err := generateReport(rows, *reportTemplate)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to generate report", "date", date, "error", err)
+		return
+	}
+	fmt.Print(report)
+
+	if *reportWebhook == "" {
+		return
+	}
+	body, err := json.Marshal(map[string]string{"date": date, "text": report})
+	if err != nil {
+		return
+	}
+	if err := postJSON(ctx, *reportWebhook, body); err != nil {
+		slog.ErrorContext(ctx, "Failed to post report", "date", date, "error", err)
+	}
+}
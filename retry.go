@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"oula-transfer/internal/transfer"
+)
+
+// retryQueryScalar wraps transfer.QueryScalar, retrying transient failures up to
+// maxAttempts times with exponential backoff plus jitter. Permanent errors
+// such as syntax errors or permission problems are returned immediately.
+func retryQueryScalar[T transfer.Scalar](ctx context.Context, db *sql.DB, query string, maxAttempts int, baseDelay time.Duration, args ...any) (T, error) {
+	var zero T
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		var v T
+		v, err = transfer.QueryScalar[T](ctx, db, *queryTimeout, query, args...)
+		if err == nil {
+			return v, nil
+		}
+		if !isTransientError(err) || attempt == maxAttempts {
+			break
+		}
+		delay := backoffDelay(baseDelay, attempt)
+		slog.Warn("Query failed, retrying", "attempt", attempt, "max_attempts", maxAttempts, "delay", delay.String(), "error", redact(err))
+		select {
+		case <-ctx.Done():
+			return zero, errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+	return zero, err
+}
+
+// backoffDelay returns baseDelay * 2^(attempt-1) plus up to 50% random jitter.
+func backoffDelay(baseDelay time.Duration, attempt int) time.Duration {
+	if baseDelay <= 0 {
+		return 0
+	}
+	delay := baseDelay << (attempt - 1)
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// isTransientError reports whether err is worth retrying: dropped or refused
+// connections, deadlocks, serialization failures and lock timeouts.
+func isTransientError(err error) bool {
+	// context.DeadlineExceeded satisfies net.Error, but a query that ran
+	// into -queryTimeout would only time out again.
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code[:min(2, len(pgErr.Code))] {
+		case "08", // connection_exception
+			"53": // insufficient_resources
+			return true
+		}
+		// Of class 57 only the shutdowns are transient; 57014
+		// query_canceled is what statement_timeout raises.
+		switch pgErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"55P03", // lock_not_available
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	// A failed lookup is wrapped in a dial *net.OpError, but a host that
+	// does not exist will not appear on a retry; only a lookup that timed
+	// out is worth another attempt.
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "read") {
+		return true
+	}
+	return strings.Contains(err.Error(), "connection reset") || strings.Contains(err.Error(), "connection refused")
+}
+
+func validateRetryFlags(maxRetries int, baseDelay time.Duration) error {
+	if maxRetries < 1 {
+		return fmt.Errorf("maxRetries must be at least 1, got %d", maxRetries)
+	}
+	if baseDelay < 0 {
+		return fmt.Errorf("retryBaseDelay must not be negative, got %s", baseDelay)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"wrapped deadline exceeded", fmt.Errorf("failed to execute query: %w", context.DeadlineExceeded), false},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"too many connections", &pgconn.PgError{Code: "53300"}, true},
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"lock not available", &pgconn.PgError{Code: "55P03"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"crash shutdown", &pgconn.PgError{Code: "57P02"}, true},
+		{"cannot connect now", &pgconn.PgError{Code: "57P03"}, true},
+		{"statement timeout", &pgconn.PgError{Code: "57014"}, false},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, false},
+		{"permission denied", &pgconn.PgError{Code: "42501"}, false},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"connection refused", syscall.ECONNREFUSED, true},
+		{"net error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")}, true},
+		{"read error", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("broken pipe")}, true},
+		{"write error", &net.OpError{Op: "write", Net: "tcp", Err: errors.New("broken pipe")}, false},
+		{"write timeout", &net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{"unknown host", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "pg.invalid", IsNotFound: true}}, false},
+		{"bare unknown host", &net.DNSError{Err: "no such host", Name: "pg.invalid", IsNotFound: true}, false},
+		{"lookup timeout", &net.DNSError{Err: "i/o timeout", Name: "pg.internal", IsTimeout: true}, true},
+		{"connection reset text", errors.New("read: connection reset by peer"), true},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err); got != tt.want {
+				t.Errorf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt := 1; attempt <= 5; attempt++ {
+		lo := base << (attempt - 1)
+		hi := lo + lo/2
+		for i := 0; i < 20; i++ {
+			if d := backoffDelay(base, attempt); d < lo || d > hi {
+				t.Fatalf("backoffDelay(%s, %d) = %s, want within [%s, %s]", base, attempt, d, lo, hi)
+			}
+		}
+	}
+	if d := backoffDelay(0, 3); d != 0 {
+		t.Errorf("backoffDelay(0, 3) = %s, want 0", d)
+	}
+}
+
+func TestRetryQueryScalar(t *testing.T) {
+	const query = "SELECT COUNT(*) FROM machine"
+	transient := &pgconn.PgError{Code: "40001"}
+	permanent := &pgconn.PgError{Code: "42601"}
+	unknownHost := &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "pg.invalid", IsNotFound: true}}
+
+	tests := []struct {
+		name        string
+		failures    []error
+		maxAttempts int
+		want        int64
+		wantErr     error
+	}{
+		{"first attempt", nil, 3, 7, nil},
+		{"recovers after transient failures", []error{transient, transient}, 3, 7, nil},
+		{"gives up after maxAttempts", []error{transient, transient, transient}, 3, 0, transient},
+		{"permanent error is not retried", []error{permanent}, 3, 0, permanent},
+		{"unknown host is not retried", []error{unknownHost}, 3, 0, unknownHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for _, err := range tt.failures {
+				mock.ExpectQuery(query).WillReturnError(err)
+			}
+			if len(tt.failures) < tt.maxAttempts && tt.wantErr == nil {
+				mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.want))
+			}
+
+			got, err := retryQueryScalar[int64](context.Background(), db, query, tt.maxAttempts, time.Millisecond)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// A cancelled query is not transient, so it is returned without a retry.
+func TestRetryQueryScalarCancelled(t *testing.T) {
+	const query = "SELECT COUNT(*) FROM machine"
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(query).WillDelayFor(time.Minute).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	started := time.Now()
+	_, err := retryQueryScalar[int64](ctx, db, query, 3, time.Minute)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("returned after %s, want soon after the cancel at 20ms", elapsed)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"oula-transfer/internal/transfer"
+)
+
+// s3Config says where uploadToS3 stores each run's metrics.
+type s3Config struct {
+	Bucket string
+	Prefix string
+	Region string
+}
+
+func s3ConfigFromFlags() s3Config {
+	return s3Config{Bucket: *s3Bucket, Prefix: *s3Prefix, Region: *s3Region}
+}
+
+// s3ObjectKey returns <prefix>/<date>/<hostname>_<timestamp>.json.
+func s3ObjectKey(prefix, date, host string, at time.Time) string {
+	return path.Join(prefix, date, fmt.Sprintf("%s_%s.json", host, at.UTC().Format("20060102T150405Z")))
+}
+
+// uploadToS3 stores rows as a JSON array in a new object for this run.
+// Credentials come from the standard AWS chain: environment, shared config
+// files or the instance profile.
+func uploadToS3(ctx context.Context, cfg s3Config, rows []metricRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	body, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	key := s3ObjectKey(cfg.Prefix, rows[0].Date, host, time.Now())
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	_, err = s3.NewFromConfig(awsCfg).PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(cfg.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload metrics to s3://%s/%s: %w", cfg.Bucket, key, transfer.ContextError(ctx, err))
+	}
+	slog.Info("Uploaded metrics to S3", "bucket", cfg.Bucket, "key", key, "rows", len(rows))
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// user:pass@... prefix of URL or go-sql-driver/mysql DSNs; the password
+	// may itself contain '@', so match up to the last one.
+	userinfoPattern = regexp.MustCompile(`^((?:[a-z][a-z0-9+.-]*://)?[^:/@]*):(.*)@`)
+	// password=... in libpq key/value DSNs, quoted or not.
+	keyValuePasswordPattern = regexp.MustCompile(`(?i)(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+)
+
+// sanitizeDSN replaces the password in a DSN with "***" so it can be logged.
+// URL-style DSNs are parsed with net/url; MySQL and key/value DSNs fall back
+// to pattern matching.
+func sanitizeDSN(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		if u, err := url.Parse(dsn); err == nil {
+			if _, ok := u.User.Password(); ok {
+				return strings.Replace(u.Redacted(), ":xxxxx@", ":***@", 1)
+			}
+			return keyValuePasswordPattern.ReplaceAllString(dsn, "${1}***")
+		}
+	}
+	dsn = keyValuePasswordPattern.ReplaceAllString(dsn, "${1}***")
+	return userinfoPattern.ReplaceAllString(dsn, "${1}:***@")
+}
+
+// redactedError hides DSNs in an error message while keeping the original
+// error available to errors.Is and errors.As.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactDSN returns err with every occurrence of the given DSNs, and of
+// their passwords, replaced by the sanitized form.
+func redactDSN(err error, dsns ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, dsn := range dsns {
+		if dsn == "" {
+			continue
+		}
+		msg = strings.ReplaceAll(msg, dsn, sanitizeDSN(dsn))
+		if pw := dsnPassword(dsn); len(pw) > 3 {
+			msg = strings.ReplaceAll(msg, pw, "***")
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+// dsnPassword extracts the password from a DSN, or "" if none is found.
+func dsnPassword(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && strings.Contains(dsn, "://") {
+		if pw, ok := u.User.Password(); ok {
+			return pw
+		}
+	}
+	if m := keyValuePasswordPattern.FindStringSubmatch(dsn); m != nil {
+		return strings.Trim(m[2], "'")
+	}
+	if m := userinfoPattern.FindStringSubmatch(dsn); m != nil {
+		return m[2]
+	}
+	return ""
+}
+
+// redact applies redactDSN with the configured database DSNs.
+func redact(err error) error {
+	return redactDSN(err, append(pgSources(), mysqlTargets()...)...)
+}
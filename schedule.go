@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"oula-transfer/internal/transfer"
+)
+
+const defaultExecutionTime = "23:00"
+
+// timeList collects repeated -executionTime flags. Each entry is either a
+// legacy "HH:MM [Zone]" daily time or a 5-field cron expression.
+type timeList []string
+
+func (t *timeList) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *timeList) Set(value string) error {
+	if err := validateSchedule(value); err != nil {
+		return err
+	}
+	*t = append(*t, value)
+	return nil
+}
+
+// orDefault returns the configured times, or the default daily time when
+// none were given.
+func (t timeList) orDefault() []string {
+	if len(t) == 0 {
+		return []string{defaultExecutionTime}
+	}
+	return t
+}
+
+// loadTimezone resolves the -timezone flag; an empty name means the host's
+// local timezone.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// nextRun returns the first occurrence of hour:minute in loc strictly after
+// now. The next day is computed with time.Date so DST changes keep the
+// wall-clock time instead of drifting by an hour.
+func nextRun(now time.Time, hour, minute int, loc *time.Location) time.Time {
+	now = now.In(loc)
+	execution := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, loc)
+	if !execution.After(now) {
+		execution = time.Date(now.Year(), now.Month(), now.Day()+1, hour, minute, 0, 0, loc)
+	}
+	return execution
+}
+
+// isLegacyTime reports whether expr uses the "HH:MM [Zone]" form rather than
+// a cron expression.
+func isLegacyTime(expr string) bool {
+	fields := strings.Fields(expr)
+	return len(fields) >= 1 && len(fields) <= 2 && strings.Contains(fields[0], ":")
+}
+
+func validateSchedule(expr string) error {
+	_, err := nextRunTime(expr, time.Now(), time.Local)
+	return err
+}
+
+// nextRunTime returns the first time after from matched by expr, which is
+// either "HH:MM [Zone]" or a standard 5-field cron expression such as
+// "0 23 * * *" (descriptors like "@daily" and a CRON_TZ= prefix also work).
+// Times without an explicit zone are interpreted in loc.
+func nextRunTime(expr string, from time.Time, loc *time.Location) (time.Time, error) {
+	if isLegacyTime(expr) {
+		hour, minute, entryLoc, err := transfer.ParseExecutionTime(expr)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if entryLoc == nil {
+			entryLoc = loc
+		}
+		return nextRun(from, hour, minute, entryLoc), nil
+	}
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid execution time %q, expected HH:MM or a cron expression: %w", expr, err)
+	}
+	next := schedule.Next(from.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never fires", expr)
+	}
+	return next, nil
+}
+
+// nextExecution returns the earliest upcoming time across all entries in
+// times. Entries without their own zone are interpreted in loc.
+func nextExecution(times []string, loc *time.Location) (time.Time, error) {
+	return nextExecutionFrom(time.Now(), times, loc)
+}
+
+func nextExecutionFrom(now time.Time, times []string, loc *time.Location) (time.Time, error) {
+	if len(times) == 0 {
+		return time.Time{}, fmt.Errorf("no execution times configured")
+	}
+	var next time.Time
+	for _, t := range times {
+		candidate, err := nextRunTime(t, now, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if next.IsZero() || candidate.Before(next) {
+			next = candidate
+		}
+	}
+	return next, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestNextRun(t *testing.T) {
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	tests := []struct {
+		name         string
+		now          time.Time
+		hour, minute int
+		loc          *time.Location
+		want         time.Time
+	}{
+		{
+			name: "UTC later today",
+			now:  time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+			hour: 23, loc: time.UTC,
+			want: time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "UTC already passed",
+			now:  time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC),
+			hour: 23, loc: time.UTC,
+			want: time.Date(2024, 1, 16, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			// 16:00 UTC is already midnight in Shanghai.
+			name: "positive offset",
+			now:  time.Date(2024, 1, 15, 16, 0, 0, 0, time.UTC),
+			hour: 23, loc: shanghai,
+			want: time.Date(2024, 1, 16, 23, 0, 0, 0, shanghai),
+		},
+		{
+			// 02:00 UTC is still the previous evening in New York.
+			name: "negative offset",
+			now:  time.Date(2024, 1, 16, 2, 0, 0, 0, time.UTC),
+			hour: 23, loc: newYork,
+			want: time.Date(2024, 1, 15, 23, 0, 0, 0, newYork),
+		},
+		{
+			name: "spring forward keeps wall-clock time",
+			now:  time.Date(2024, 3, 9, 23, 30, 0, 0, newYork),
+			hour: 23, loc: newYork,
+			want: time.Date(2024, 3, 10, 23, 0, 0, 0, newYork),
+		},
+		{
+			name: "fall back keeps wall-clock time",
+			now:  time.Date(2024, 11, 2, 23, 30, 0, 0, newYork),
+			hour: 23, loc: newYork,
+			want: time.Date(2024, 11, 3, 23, 0, 0, 0, newYork),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextRun(tt.now, tt.hour, tt.minute, tt.loc)
+			if !got.Equal(tt.want) {
+				t.Errorf("nextRun(%s, %02d:%02d, %s) = %s, want %s", tt.now, tt.hour, tt.minute, tt.loc, got, tt.want)
+			}
+			if h, m, _ := got.In(tt.loc).Clock(); h != tt.hour || m != tt.minute {
+				t.Errorf("nextRun wall clock = %02d:%02d, want %02d:%02d", h, m, tt.hour, tt.minute)
+			}
+		})
+	}
+}
+
+func TestNextExecutionFrom(t *testing.T) {
+	tests := []struct {
+		name    string
+		now     time.Time
+		times   []string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:  "earliest of several",
+			now:   time.Date(2024, 1, 15, 7, 0, 0, 0, time.UTC),
+			times: []string{"23:00", "08:00"},
+			want:  time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "between entries",
+			now:   time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC),
+			times: []string{"08:00", "23:00"},
+			want:  time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "crosses midnight",
+			now:   time.Date(2024, 1, 15, 23, 30, 0, 0, time.UTC),
+			times: []string{"08:00", "23:00"},
+			want:  time.Date(2024, 1, 16, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "crosses month end",
+			now:   time.Date(2024, 1, 31, 23, 59, 0, 0, time.UTC),
+			times: []string{"00:00"},
+			want:  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "duplicate times",
+			now:   time.Date(2024, 1, 15, 7, 0, 0, 0, time.UTC),
+			times: []string{"08:00", "08:00"},
+			want:  time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "no times",
+			now:     time.Date(2024, 1, 15, 7, 0, 0, 0, time.UTC),
+			wantErr: true,
+		},
+		{
+			name:    "invalid entry",
+			now:     time.Date(2024, 1, 15, 7, 0, 0, 0, time.UTC),
+			times:   []string{"08:00", "25:00"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextExecutionFrom(tt.now, tt.times, time.UTC)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("nextExecutionFrom(%v) = %s, want error", tt.times, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("nextExecutionFrom(%v): %v", tt.times, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("nextExecutionFrom(%v) = %s, want %s", tt.times, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextRunTime(t *testing.T) {
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+	// Monday 2024-01-15 10:30 UTC.
+	from := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		expr    string
+		loc     *time.Location
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "legacy time", expr: "23:00", loc: time.UTC, want: time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)},
+		{name: "legacy time with zone", expr: "08:00 Asia/Shanghai", loc: time.UTC, want: time.Date(2024, 1, 16, 8, 0, 0, 0, shanghai)},
+		{name: "daily", expr: "0 23 * * *", loc: time.UTC, want: time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)},
+		{name: "every six hours", expr: "0 */6 * * *", loc: time.UTC, want: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)},
+		{name: "weekdays", expr: "0 10 * * 1-5", loc: time.UTC, want: time.Date(2024, 1, 16, 10, 0, 0, 0, time.UTC)},
+		{name: "weekends", expr: "0 10 * * 6,0", loc: time.UTC, want: time.Date(2024, 1, 20, 10, 0, 0, 0, time.UTC)},
+		{name: "month and day", expr: "15 2 1 3 *", loc: time.UTC, want: time.Date(2024, 3, 1, 2, 15, 0, 0, time.UTC)},
+		{name: "named weekday", expr: "0 9 * * MON", loc: time.UTC, want: time.Date(2024, 1, 22, 9, 0, 0, 0, time.UTC)},
+		{name: "descriptor", expr: "@daily", loc: time.UTC, want: time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{name: "hourly descriptor", expr: "@hourly", loc: time.UTC, want: time.Date(2024, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{name: "in loc", expr: "0 23 * * *", loc: shanghai, want: time.Date(2024, 1, 15, 23, 0, 0, 0, shanghai)},
+		{name: "CRON_TZ prefix", expr: "CRON_TZ=Asia/Shanghai 0 8 * * *", loc: time.UTC, want: time.Date(2024, 1, 16, 8, 0, 0, 0, shanghai)},
+		{name: "six fields", expr: "0 0 23 * * *", loc: time.UTC, wantErr: true},
+		{name: "minute out of range", expr: "60 23 * * *", loc: time.UTC, wantErr: true},
+		{name: "garbage", expr: "tonight", loc: time.UTC, wantErr: true},
+		{name: "never fires", expr: "0 0 30 2 *", loc: time.UTC, wantErr: true},
+		{name: "invalid legacy time", expr: "23:60", loc: time.UTC, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextRunTime(tt.expr, from, tt.loc)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("nextRunTime(%q) = %s, want error", tt.expr, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("nextRunTime(%q): %v", tt.expr, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("nextRunTime(%q) = %s, want %s", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
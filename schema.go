@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"oula-transfer/internal/transfer"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// tableSchema is the DDL that creates one MySQL table.
+type tableSchema struct {
+	Name string
+	DDL  string
+}
+
+// schemaTables returns the DDL for every table the metrics and derived
+// metrics write to, plus the transfer_runs history table.
+func schemaTables(metrics []MetricQuery, derived []DerivedMetric) ([]tableSchema, error) {
+	metricDDL, err := migrationFiles.ReadFile("migrations/metric_table.sql")
+	if err != nil {
+		return nil, err
+	}
+	floatDDL, err := migrationFiles.ReadFile("migrations/float_metric_table.sql")
+	if err != nil {
+		return nil, err
+	}
+	stringDDL, err := migrationFiles.ReadFile("migrations/string_metric_table.sql")
+	if err != nil {
+		return nil, err
+	}
+	derivedDDL, err := migrationFiles.ReadFile("migrations/derived_metric_table.sql")
+	if err != nil {
+		return nil, err
+	}
+	runsDDL, err := migrationFiles.ReadFile("migrations/transfer_runs.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []tableSchema
+	seen := make(map[string]bool)
+	for _, m := range metrics {
+		table := metricTable(m.MySQLTable)
+		if seen[table] {
+			continue
+		}
+		seen[table] = true
+		ddl := metricDDL
+		precision, scale := 20, 8
+		switch {
+		case m.isString():
+			ddl = stringDDL
+		case m.isFloat():
+			ddl = floatDDL
+			if m.Scale > 0 {
+				precision, scale = 10, m.Scale
+			}
+		}
+		tables = append(tables, tableSchema{
+			Name: table,
+			DDL: strings.NewReplacer(
+				"{{table}}", table,
+				"{{precision}}", strconv.Itoa(precision),
+				"{{scale}}", strconv.Itoa(scale),
+			).Replace(string(ddl)),
+		})
+	}
+	for _, d := range derived {
+		table := metricTable(d.MySQLTable)
+		if seen[table] {
+			continue
+		}
+		seen[table] = true
+		tables = append(tables, tableSchema{
+			Name: table,
+			DDL: strings.NewReplacer(
+				"{{table}}", table,
+				"{{scale}}", strconv.Itoa(d.scale()),
+			).Replace(string(derivedDDL)),
+		})
+	}
+	return append(tables, tableSchema{Name: "transfer_runs", DDL: string(runsDDL)}), nil
+}
+
+// runMigrations creates any missing tables. Every statement uses CREATE
+// TABLE IF NOT EXISTS, so running it against an up-to-date schema is a no-op.
+func runMigrations(ctx context.Context, db *sql.DB, tables []tableSchema) error {
+	for _, t := range tables {
+		ctx, cancel := withQueryTimeout(ctx)
+		_, err := db.ExecContext(ctx, t.DDL)
+		err = transfer.ContextError(ctx, err)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to create table %s: %w", t.Name, err)
+		}
+		slog.Debug("Table is up to date", "table", t.Name)
+	}
+	slog.Info("MySQL schema migrated", "tables", len(tables))
+	return nil
+}
+
+// migrateAll runs runMigrations against every MySQL destination.
+func migrateAll(ctx context.Context, dsns []string, metrics []MetricQuery, derived []DerivedMetric) error {
+	tables, err := schemaTables(metrics, derived)
+	if err != nil {
+		return err
+	}
+	for _, dsn := range dsns {
+		db, err := openMySQL(dsn, mysqlTLSFromFlags())
+		if err != nil {
+			return redactDSN(err, dsn)
+		}
+		err = mustPingDB(ctx, db, "MySQL")
+		if err == nil {
+			err = runMigrations(ctx, db, tables)
+		}
+		db.Close()
+		if err != nil {
+			return redactDSN(err, dsn)
+		}
+	}
+	return nil
+}
+
+// requiredTables lists the MySQL tables the metrics and derived metrics
+// write to.
+func requiredTables(metrics []MetricQuery, derived []DerivedMetric) []string {
+	var tables []string
+	seen := make(map[string]bool)
+	add := func(table string) {
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+	for _, m := range metrics {
+		add(metricTable(m.MySQLTable))
+	}
+	for _, d := range derived {
+		add(metricTable(d.MySQLTable))
+	}
+	return tables
+}
+
+// validateMySQLSchema checks that every table exists in the current
+// database, so a missing table is reported up front instead of as an insert
+// failure halfway through a transfer.
+func validateMySQLSchema(ctx context.Context, db *sql.DB, tables []string) error {
+	var missing []string
+	for _, table := range tables {
+		qctx, cancel := withQueryTimeout(ctx)
+		var one int
+		err := db.QueryRowContext(qctx,
+			"SELECT 1 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?", table).Scan(&one)
+		err = transfer.ContextError(qctx, err)
+		cancel()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			missing = append(missing, table)
+		case err != nil:
+			return fmt.Errorf("failed to check MySQL table %s: %w", table, err)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing MySQL tables: %s (run with -autoMigrate to create them)", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// runCheckSchemaOnly checks PostgreSQL connectivity and the MySQL schema
+// without transferring anything, for use as a CI or init-container gate.
+func runCheckSchemaOnly(ctx context.Context) error {
+	var errs []error
+	for _, target := range transferTargets() {
+		for _, dsn := range target.pgDsns {
+			if err := pingPostgres(ctx, dsn); err != nil {
+				errs = append(errs, target.wrap(err))
+			}
+		}
+		tables := requiredTables(target.queries, target.derived)
+		for _, dsn := range target.mysqlDsns {
+			db, err := openMySQL(dsn, mysqlTLSFromFlags())
+			if err == nil {
+				err = mustPingDB(ctx, db, "MySQL")
+				if err == nil {
+					err = validateMySQLSchema(ctx, db, tables)
+				}
+				db.Close()
+			}
+			if err != nil {
+				errs = append(errs, target.wrap(redactDSN(err, dsn)))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// simulatedMax bounds the synthetic counts produced by -simulate.
+const simulatedMax = 1000
+
+// simulator hands out synthetic counts for -simulate. With a non-zero seed
+// each metric gets the same count on every run, whatever order the workers
+// happen to query them in, so inserts and upserts can be compared across
+// runs.
+type simulator struct {
+	seed int64
+}
+
+func newSimulator(seed int64) *simulator {
+	return &simulator{seed: seed}
+}
+
+func (s *simulator) count(metric string) int {
+	if s.seed == 0 {
+		return rand.Intn(simulatedMax)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(metric))
+	return rand.New(rand.NewSource(s.seed ^ int64(h.Sum64()))).Intn(simulatedMax)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+// slackRateLimitDelay is how long to wait after a 429 when Slack does not
+// send a Retry-After header; tests shorten it.
+var slackRateLimitDelay = time.Second
+
+// sendSlackAlert posts message to a Slack Incoming Webhook. A 429 response is
+// retried once after the delay Slack asks for.
+func sendSlackAlert(ctx context.Context, webhookURL, message string) error {
+	body, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := postSlack(ctx, webhookURL, body)
+		if err == nil || retryAfter < 0 || attempt == 2 {
+			return err
+		}
+		slog.Warn("Slack rate limited the webhook, retrying", "delay", retryAfter.String())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// postSlack sends one webhook request. On a 429 it returns the delay to wait
+// before retrying; otherwise it returns a negative delay.
+func postSlack(ctx context.Context, url string, body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		delay := slackRateLimitDelay
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			delay = time.Duration(secs) * time.Second
+		}
+		return delay, fmt.Errorf("slack webhook returned %s", resp.Status)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return -1, fmt.Errorf("slack webhook returned %s", resp.Status)
+	}
+	return -1, nil
+}
+
+// slackMessage formats a transfer outcome for Slack, linking to
+// -dashboardURL when it is set.
+func slackMessage(res transfer.Summary, err error) string {
+	var b strings.Builder
+	if err != nil {
+		fmt.Fprintf(&b, ":red_circle: *oula-transfer failed* for %s\n", res.Date)
+		if len(res.FailedMetrics) > 0 {
+			fmt.Fprintf(&b, "*Failed metrics:* %s\n", strings.Join(res.FailedMetrics, ", "))
+		}
+		fmt.Fprintf(&b, "*Error:* ```%s```\n", err)
+	} else {
+		fmt.Fprintf(&b, ":large_green_circle: *oula-transfer succeeded* for %s: %d metrics in %s\n",
+			res.Date, res.MetricsSucceeded, res.Duration.Round(time.Millisecond))
+	}
+	if *dashboardURL != "" {
+		fmt.Fprintf(&b, "<%s|Open dashboard>\n", *dashboardURL)
+	}
+	return b.String()
+}
+
+// notifySlack reports a transfer to -slackWebhook: always on failure, and on
+// success only with -slackOnSuccess.
+func notifySlack(ctx context.Context, res transfer.Summary, err error) {
+	if *slackWebhook == "" || (err == nil && !*slackOnSuccess) {
+		return
+	}
+	if sendErr := sendSlackAlert(ctx, *slackWebhook, slackMessage(res, err)); sendErr != nil {
+		slog.Error("Failed to send Slack notification", "error", sendErr)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+func TestSendSlackAlert(t *testing.T) {
+	slackRateLimitDelay = time.Millisecond
+	defer func() { slackRateLimitDelay = time.Second }()
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantErr      bool
+	}{
+		{"delivered", []int{http.StatusOK}, 1, false},
+		{"rate limited once", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+		{"rate limited twice", []int{http.StatusTooManyRequests}, 2, true},
+		{"server error is not retried", []int{http.StatusInternalServerError}, 1, true},
+		{"invalid token", []int{http.StatusForbidden}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq := &statusSequence[map[string]string]{statuses: tt.statuses}
+			srv := httptest.NewServer(seq)
+			defer srv.Close()
+
+			err := sendSlackAlert(context.Background(), srv.URL, "transfer failed")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendSlackAlert error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := seq.requests(); got != tt.wantRequests {
+				t.Fatalf("webhook got %d requests, want %d", got, tt.wantRequests)
+			}
+			for i, body := range seq.bodies {
+				if body["text"] != "transfer failed" {
+					t.Errorf("request %d text = %q", i, body["text"])
+				}
+			}
+		})
+	}
+}
+
+func TestPostSlackRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	delay, err := postSlack(context.Background(), srv.URL, []byte(`{"text":"x"}`))
+	if err == nil {
+		t.Fatal("postSlack succeeded on a 429")
+	}
+	if delay != 7*time.Second {
+		t.Errorf("delay = %s, want the Retry-After of 7s", delay)
+	}
+}
+
+func TestSlackMessage(t *testing.T) {
+	res := transfer.Summary{
+		Date:             "2024-01-15",
+		MetricsSucceeded: 4,
+		Duration:         1234567 * time.Microsecond,
+		FailedMetrics:    []string{"lost_users_count", "new_users_count"},
+	}
+	tests := []struct {
+		name      string
+		err       error
+		dashboard string
+		want      []string
+		wantNot   []string
+	}{
+		{
+			name:    "failure",
+			err:     errors.New("query timed out"),
+			want:    []string{"failed* for 2024-01-15", "lost_users_count, new_users_count", "query timed out"},
+			wantNot: []string{"Open dashboard"},
+		},
+		{
+			name:    "success",
+			want:    []string{"succeeded* for 2024-01-15: 4 metrics in 1.235s"},
+			wantNot: []string{"Failed metrics"},
+		},
+		{
+			name:      "dashboard link",
+			err:       errors.New("boom"),
+			dashboard: "https://grafana.example.com/d/oula",
+			want:      []string{"<https://grafana.example.com/d/oula|Open dashboard>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "dashboardURL", tt.dashboard)
+			msg := slackMessage(res, tt.err)
+			for _, s := range tt.want {
+				if !strings.Contains(msg, s) {
+					t.Errorf("message %q does not contain %q", msg, s)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(msg, s) {
+					t.Errorf("message %q contains %q", msg, s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"oula-transfer/internal/transfer"
+)
+
+// dsnList collects -pgDsns and -mysqlDsns values. A command-line value may
+// hold several comma-separated DSNs, and repeated flags append. Entries of a
+// config list are taken whole, since a multi-host DSN such as
+// "postgres://h1,h2/db" contains commas itself.
+type dsnList []string
+
+func (l *dsnList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *dsnList) Set(value string) error {
+	for _, dsn := range strings.Split(value, ",") {
+		l.appendEntry(dsn)
+	}
+	return nil
+}
+
+func (l *dsnList) appendEntry(dsn string) {
+	if dsn = strings.TrimSpace(dsn); dsn != "" {
+		*l = append(*l, dsn)
+	}
+}
+
+// pgSources returns the PostgreSQL DSNs to read from: the -pgDsns list when
+// given, otherwise the single -pgDsn.
+func pgSources() []string {
+	if len(pgDsnList) > 0 {
+		return pgDsnList
+	}
+	return []string{*pgDsn}
+}
+
+// mysqlTargets is pgSources for the MySQL destinations. It is empty when no
+// MySQL DSN is configured, which -outputStdout allows.
+func mysqlTargets() []string {
+	if len(mysqlDsnList) > 0 {
+		return mysqlDsnList
+	}
+	if *mysqlDsn == "" {
+		return nil
+	}
+	return []string{*mysqlDsn}
+}
+
+// queryAllSources runs query against every source in parallel and returns
+// the value from each, in source order. Every source is always queried to
+// completion; failures are returned joined, with zero values in their slots.
+func queryAllSources[T transfer.Scalar](ctx context.Context, dbs []*sql.DB, query string, args ...any) ([]T, error) {
+	values := make([]T, len(dbs))
+	if len(dbs) == 1 {
+		v, err := retryQueryScalar[T](ctx, dbs[0], query, *maxRetries, *retryDelay, args...)
+		values[0] = v
+		return values, err
+	}
+
+	errs := make([]error, len(dbs))
+	var wg sync.WaitGroup
+	for i, db := range dbs {
+		wg.Add(1)
+		go func(i int, db *sql.DB) {
+			defer wg.Done()
+			v, err := retryQueryScalar[T](ctx, db, query, *maxRetries, *retryDelay, args...)
+			if err != nil {
+				errs[i] = fmt.Errorf("source #%d: %w", i+1, err)
+				return
+			}
+			values[i] = v
+		}(i, db)
+	}
+	wg.Wait()
+	return values, errors.Join(errs...)
+}
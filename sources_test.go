@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDSNListSet(t *testing.T) {
+	var l dsnList
+	for _, v := range []string{"postgres://pg-eu/oula, postgres://pg-us/oula", "postgres://pg-ap/oula", " , "} {
+		if err := l.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := dsnList{"postgres://pg-eu/oula", "postgres://pg-us/oula", "postgres://pg-ap/oula"}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("dsnList = %q, want %q", l, want)
+	}
+}
+
+func TestApplyConfigKeepsDSNsWhole(t *testing.T) {
+	oldPg, oldTenants := pgDsnList, tenants
+	defer func() { pgDsnList, tenants = oldPg, oldTenants }()
+	pgDsnList = nil
+
+	cfg := &Config{PgDsns: stringList{
+		"postgres://user@h1,h2/oula?target_session_attrs=read-write",
+		"host=pg-a,pg-b dbname=oula",
+	}}
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := dsnList(cfg.PgDsns); !reflect.DeepEqual(pgDsnList, want) {
+		t.Errorf("pgDsnList = %q, want %q", pgDsnList, want)
+	}
+}
+
+func TestNameListSet(t *testing.T) {
+	var l nameList
+	for _, v := range []string{"active_machines_count_aleo,lost_users_count", " new_users_count "} {
+		if err := l.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := nameList{"active_machines_count_aleo", "lost_users_count", "new_users_count"}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("nameList = %q, want %q", l, want)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"oula-transfer/internal/transfer"
+)
+
+// transferStatus remembers the outcome of the most recent transfer for the
+// /status endpoint.
+type transferStatus struct {
+	mu                 sync.RWMutex
+	lastRunAt          time.Time
+	lastRunSucceeded   bool
+	lastRunDuration    time.Duration
+	metricsTransferred int
+	metricsFailed      int
+	failedMetrics      []string
+	lastError          error
+}
+
+var lastTransfer transferStatus
+
+func (s *transferStatus) record(res transfer.Summary, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRunAt = res.StartedAt
+	s.lastRunSucceeded = err == nil
+	s.lastRunDuration = res.Duration
+	s.metricsTransferred = res.MetricsSucceeded
+	s.metricsFailed = res.MetricsFailed
+	s.failedMetrics = res.FailedMetrics
+	s.lastError = err
+}
+
+type statusResponse struct {
+	LastRunAt          *string  `json:"last_run_at"`
+	LastRunSucceeded   bool     `json:"last_run_succeeded"`
+	LastRunDurationMs  int64    `json:"last_run_duration_ms"`
+	MetricsTransferred int      `json:"metrics_transferred"`
+	MetricsFailed      int      `json:"metrics_failed"`
+	FailedMetrics      []string `json:"failed_metrics"`
+	LastError          *string  `json:"last_error"`
+	Version            string   `json:"version"`
+	Commit             string   `json:"commit"`
+	BuildTime          string   `json:"build_time"`
+}
+
+func (s *transferStatus) snapshot() statusResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	resp := statusResponse{
+		LastRunSucceeded:   s.lastRunSucceeded,
+		LastRunDurationMs:  s.lastRunDuration.Milliseconds(),
+		MetricsTransferred: s.metricsTransferred,
+		MetricsFailed:      s.metricsFailed,
+		FailedMetrics:      s.failedMetrics,
+		Version:            orUnknown(Version),
+		Commit:             orUnknown(Commit),
+		BuildTime:          orUnknown(BuildTime),
+	}
+	if !s.lastRunAt.IsZero() {
+		at := s.lastRunAt.Format(time.RFC3339)
+		resp.LastRunAt = &at
+	}
+	if s.lastError != nil {
+		msg := s.lastError.Error()
+		resp.LastError = &msg
+	}
+	return resp
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, lastTransfer.snapshot())
+}
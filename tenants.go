@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Tenant is one customer served by the same binary, with its own source,
+// destination and schedule. It is only configurable from the config file's
+// tenants section.
+type Tenant struct {
+	Name          string     `yaml:"name" json:"name"`
+	PgDsn         string     `yaml:"pgDsn" json:"pgDsn"`
+	MysqlDsn      string     `yaml:"mysqlDsn" json:"mysqlDsn"`
+	ExecutionTime stringList `yaml:"executionTime" json:"executionTime"`
+	// Metrics, when set, limits the tenant to these metric names; derived
+	// metrics are listed by name too.
+	Metrics []string `yaml:"metrics" json:"metrics"`
+}
+
+// tenants is the tenants section of the config file; with no tenants the
+// top-level flags describe the single transfer.
+var tenants []Tenant
+
+type tenantKey struct{}
+
+// withTenant returns a context whose transfers run for t.
+func withTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// tenantFromContext returns the tenant ctx runs for, or nil outside tenant
+// mode.
+func tenantFromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(tenantKey{}).(*Tenant)
+	return t
+}
+
+// metricsFor returns the metrics a transfer for t collects: everything when
+// t is nil or lists no metrics.
+func metricsFor(t *Tenant) ([]MetricQuery, []DerivedMetric) {
+	if t == nil || len(t.Metrics) == 0 {
+		return metricQueries, derivedMetrics
+	}
+	want := make(map[string]bool, len(t.Metrics))
+	for _, name := range t.Metrics {
+		want[name] = true
+	}
+	var queries []MetricQuery
+	for _, m := range metricQueries {
+		if want[m.Name] {
+			queries = append(queries, m)
+		}
+	}
+	var derived []DerivedMetric
+	for _, d := range derivedMetrics {
+		if want[d.Name] {
+			derived = append(derived, d)
+		}
+	}
+	return queries, derived
+}
+
+// validateTenants checks the tenants section and -tenant.
+func validateTenants() error {
+	seen := make(map[string]bool, len(tenants))
+	for _, t := range tenants {
+		if t.Name == "" {
+			return errors.New("every tenant needs a name")
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("duplicate tenant %q", t.Name)
+		}
+		seen[t.Name] = true
+		if t.PgDsn == "" || t.MysqlDsn == "" {
+			return fmt.Errorf("tenant %s: pgDsn and mysqlDsn must be provided", t.Name)
+		}
+		for _, e := range t.ExecutionTime {
+			if err := validateSchedule(e); err != nil {
+				return fmt.Errorf("tenant %s: %w", t.Name, err)
+			}
+		}
+	}
+	if *tenantName != "" && !seen[*tenantName] {
+		return fmt.Errorf("tenant %q is not configured", *tenantName)
+	}
+	return nil
+}
+
+// checkTenantMetrics reports tenant metric overrides naming metrics that
+// were not loaded.
+func checkTenantMetrics() error {
+	known := make(map[string]bool)
+	for _, m := range metricQueries {
+		known[m.Name] = true
+	}
+	for _, d := range derivedMetrics {
+		known[d.Name] = true
+	}
+	for _, t := range tenants {
+		for _, name := range t.Metrics {
+			if !known[name] {
+				return fmt.Errorf("tenant %s: unknown metric %q", t.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// selectedTenants returns the tenants to run, narrowed by -tenant.
+func selectedTenants() []*Tenant {
+	var out []*Tenant
+	for i := range tenants {
+		if *tenantName == "" || tenants[i].Name == *tenantName {
+			out = append(out, &tenants[i])
+		}
+	}
+	return out
+}
+
+// transferTarget is one set of databases and metrics: the top-level flags'
+// or, in tenant mode, one tenant's. The one-shot checks, -autoMigrate and
+// /readyz go over every target.
+type transferTarget struct {
+	tenant    string
+	pgDsns    []string
+	mysqlDsns []string
+	queries   []MetricQuery
+	derived   []DerivedMetric
+}
+
+// transferTargets returns the selected tenants, or the top-level flags
+// outside tenant mode.
+func transferTargets() []transferTarget {
+	if len(tenants) == 0 {
+		return []transferTarget{{pgDsns: pgSources(), mysqlDsns: mysqlTargets(), queries: metricQueries, derived: derivedMetrics}}
+	}
+	var targets []transferTarget
+	for _, t := range selectedTenants() {
+		queries, derived := metricsFor(t)
+		targets = append(targets, transferTarget{
+			tenant:    t.Name,
+			pgDsns:    []string{t.PgDsn},
+			mysqlDsns: []string{t.MysqlDsn},
+			queries:   queries,
+			derived:   derived,
+		})
+	}
+	return targets
+}
+
+// wrap prefixes err with the target's tenant, if it has one.
+func (t transferTarget) wrap(err error) error {
+	if err == nil || t.tenant == "" {
+		return err
+	}
+	return fmt.Errorf("tenant %s: %w", t.tenant, err)
+}
+
+// tenantsInProgress holds the tenants with a transfer running, so one
+// tenant's scheduled and manual runs do not overlap while different tenants
+// still run concurrently.
+var (
+	tenantsMu         sync.Mutex
+	tenantsInProgress = make(map[string]bool)
+)
+
+// startTenantTransfer claims the named tenant, reporting false if it was
+// already busy.
+func startTenantTransfer(name string) bool {
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+	if tenantsInProgress[name] {
+		return false
+	}
+	tenantsInProgress[name] = true
+	return true
+}
+
+func finishTenantTransfer(name string) {
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+	delete(tenantsInProgress, name)
+}
+
+// runTenantsOnce transfers forDate for every selected tenant concurrently.
+// A failing tenant does not stop the others; the returned error names the
+// tenants that failed. The caller claims the tenants with startOnce.
+func runTenantsOnce(ctx context.Context, forDate time.Time) error {
+	selected := selectedTenants()
+	errs := make([]error, len(selected))
+	var wg sync.WaitGroup
+	for i, t := range selected {
+		wg.Add(1)
+		go func(i int, t *Tenant) {
+			defer wg.Done()
+			if err := runTransfer(withTenant(ctx, t), forDate); err != nil {
+				errs[i] = fmt.Errorf("tenant %s: %w", t.Name, err)
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// runTenantSchedulers runs one scheduler per selected tenant and returns
+// once ctx is cancelled and every scheduler has stopped.
+func runTenantSchedulers(ctx context.Context, loc *time.Location) {
+	var wg sync.WaitGroup
+	for _, t := range selectedTenants() {
+		times := executionTimes.orDefault()
+		if len(t.ExecutionTime) > 0 {
+			times = t.ExecutionTime
+		}
+		tctx := withTenant(ctx, t)
+		slog.InfoContext(tctx, "Starting tenant scheduler", "execution_times", []string(times))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduleTransfers(tctx, times, loc)
+		}()
+	}
+	wg.Wait()
+}
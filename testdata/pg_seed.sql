@@ -0,0 +1,34 @@
+-- Known data for the 2024-01-15 transfer checked by TestTransferData.
+-- Timestamps are Unix seconds; 1705276800 is 2024-01-15 00:00 UTC.
+CREATE TABLE invitation_code (id INT PRIMARY KEY, tag TEXT NOT NULL);
+CREATE TABLE bonus_obj (user_id INT, project TEXT NOT NULL, tag TEXT NOT NULL);
+CREATE TABLE "user" (
+    id INT PRIMARY KEY,
+    email TEXT NOT NULL,
+    invitation_code_id INT REFERENCES invitation_code (id),
+    created_at TIMESTAMP NOT NULL
+);
+CREATE TABLE miner_account (id INT PRIMARY KEY, name TEXT NOT NULL, main_user_id INT REFERENCES "user" (id));
+CREATE TABLE machine (
+    id INT PRIMARY KEY,
+    miner_account_id INT REFERENCES miner_account (id),
+    project TEXT NOT NULL,
+    last_commit_solution BIGINT NOT NULL
+);
+
+INSERT INTO invitation_code VALUES (1, 'channel-a'), (2, 'default');
+INSERT INTO bonus_obj VALUES (NULL, 'ALEO', 'channel-a');
+INSERT INTO "user" VALUES
+    (1, 'a@example.com', 1, '2023-06-01 08:00'),
+    (2, 'b@example.com', 2, '2023-12-24 12:00'),
+    (3, 'c@example.com', NULL, '2024-01-15 09:30'); -- registered on the transfer date
+INSERT INTO miner_account VALUES
+    (1, 'acct-a', 1),
+    (2, 'acct-b', 2),
+    (3, 'acct-c', 3),
+    (4, 'acct-d', 1); -- no machines: dormant
+INSERT INTO machine VALUES
+    (1, 1, 'ALEO', 1705276800 + 3600),   -- active, channel user
+    (2, 2, 'ALEO', 1705276800 + 7200),   -- active
+    (3, 2, 'Quai', 1705276800 + 10800),  -- active
+    (4, 3, 'ALEO', 1705276800 - 259200); -- three days old: c@example.com is lost
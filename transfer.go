@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"oula-transfer/internal/transfer"
+)
+
+// transferData transfers forDate from pgDsns to mysqlDsns with a
+// transfer.Job configured from the flags, and records the run's Prometheus
+// metrics.
+func transferData(ctx context.Context, pgDsns, mysqlDsns []string, forDate time.Time) (res transfer.Summary, err error) {
+	day := forDate.Format("2006-01-02")
+	defer func() {
+		transferDuration.Observe(res.Duration.Seconds())
+		if err == nil {
+			lastSuccessTimestamp.SetToCurrentTime()
+		}
+		if *pushgatewayURL != "" {
+			if pushErr := pushMetrics(context.WithoutCancel(ctx), *pushgatewayURL, res, err); pushErr != nil {
+				slog.ErrorContext(ctx, "Failed to push metrics to the Pushgateway", "error", pushErr)
+			}
+		}
+	}()
+
+	job := newTransferJob(ctx, pgDsns, mysqlDsns, forDate)
+	if *localFallback != "" && !*dryRun && len(mysqlDsns) > 0 {
+		localDb, err := openLocalBuffer(*localFallback)
+		if err != nil {
+			now := time.Now()
+			return transfer.Summary{Date: day, MetricsAttempted: len(job.Queries) + len(job.Derived), StartedAt: now, CompletedAt: now}, err
+		}
+		defer localDb.Close()
+		job.Fallback = localBuffer{db: localDb}
+	}
+	return job.Run(ctx, day)
+}
+
+// newTransferJob configures the transfer of forDate from the flags and the
+// metrics of ctx's tenant.
+func newTransferJob(ctx context.Context, pgDsns, mysqlDsns []string, forDate time.Time) transfer.Job {
+	day := forDate.Format("2006-01-02")
+	queries, derived := metricsFor(tenantFromContext(ctx))
+
+	var sim *simulator
+	if *simulate {
+		sim = newSimulator(*simulateSeed)
+		slog.WarnContext(ctx, "Simulation mode, using synthetic counts instead of PostgreSQL", "date", day, "seed", *simulateSeed)
+	}
+	job := transfer.Job{
+		Sources:      pgDsns,
+		Destinations: mysqlDsns,
+		OpenSource: func(dsn string) (*sql.DB, error) {
+			db, err := openPostgres(dsn, pgTLSFromFlags())
+			if err == nil {
+				pgPoolFromFlags().apply(db)
+			}
+			return db, err
+		},
+		OpenDestination: func(dsn string) (*sql.DB, error) {
+			db, err := openMySQL(dsn, mysqlTLSFromFlags())
+			if err == nil {
+				mysqlPoolFromFlags().apply(db)
+			}
+			return db, err
+		},
+		Redact:         redactDSN,
+		ConnectTimeout: *connectTimeout,
+		MaxDuration:    *maxTransferDuration,
+		Workers:        *workers,
+		DryRun:         *dryRun,
+		Writer:         mysqlWriter(),
+		CheckSchema: func(ctx context.Context, db *sql.DB) error {
+			return validateMySQLSchema(ctx, db, requiredTables(queries, derived))
+		},
+		History: transferHistory{},
+		Prepare: func(ctx context.Context, db *sql.DB, results []transfer.Result) ([]transfer.Result, []error) {
+			return prepareResults(ctx, db, day, derived, results)
+		},
+		WriteFailed: func(ctx context.Context, date string, results []transfer.Result, err error) {
+			for _, r := range results {
+				queryErrors.WithLabelValues(r.Name).Inc()
+			}
+			if *localFallback == "" && *dlqPath != "" {
+				if dlqErr := writeDeadLetters(ctx, *dlqPath, date, results, err); dlqErr != nil {
+					slog.ErrorContext(ctx, "Failed to write the dead-letter queue", "path", *dlqPath, "error", dlqErr)
+				}
+			}
+		},
+		Export: func(ctx context.Context, results []transfer.Result) ([]error, error) {
+			return exportResults(ctx, day, len(mysqlDsns) > 0, results)
+		},
+		Finish: func(ctx context.Context, db *sql.DB, written []transfer.Result) []error {
+			return finishRun(ctx, db, pgDsns, forDate, written)
+		},
+	}
+	for _, m := range queries {
+		m := m
+		job.Queries = append(job.Queries, transfer.Query{Name: m.Name, Collect: func(ctx context.Context, sources []*sql.DB) (transfer.Result, error) {
+			return collectMetric(ctx, sources, pgDsns, m, forDate, sim)
+		}})
+	}
+	for _, d := range derived {
+		job.Derived = append(job.Derived, d.Name)
+	}
+	// In multi-instance deployments only one instance transfers a given
+	// date.
+	if *advisoryLockKey != 0 {
+		job.Lock = func(ctx context.Context, db *sql.DB) (func(), bool, error) {
+			return tryAdvisoryLock(ctx, db, advisoryKey(*advisoryLockKey, day))
+		}
+	}
+	return job
+}
+
+// collectMetric queries one metric for forDate from every source, or takes
+// it from the simulator or the cache, and checks the value. It runs on the
+// worker pool, so anything it shares with other metrics must be guarded.
+func collectMetric(ctx context.Context, pgDbs []*sql.DB, pgDsns []string, m MetricQuery, forDate time.Time, sim *simulator) (transfer.Result, error) {
+	day := forDate.Format("2006-01-02")
+	query := renderDate(renderQuery(m.Query, *pgSchema), forDate)
+	args := queryArgs(query, forDate)
+	queryStarted := time.Now()
+	metricCtx, span := tracer.Start(ctx, "metric "+m.Name, metricAttrs(m.Name, m.MySQLTable))
+	var count int
+	var value any
+	var err error
+	if sim != nil {
+		count = sim.count(m.Name)
+		switch {
+		case m.isString():
+			value = fmt.Sprintf("simulated-%d", count)
+		case m.isFloat():
+			value = float64(count)
+		}
+	} else if cached, ok := cachedMetric(query, args, pgDsns); ok {
+		slog.DebugContext(ctx, "Using cached metric value", "metric_name", m.Name, "date", day)
+		count, value = cached.count, cached.value
+	} else {
+		if *explain {
+			logQueryPlans(metricCtx, pgDbs, m.Name, query, args...)
+		}
+		count, value, err = queryMetricValue(metricCtx, pgDbs, m, query, args...)
+		if err == nil && *enableCache {
+			queryCache.put(cacheKey(query, args, pgDsns), count, value, *cacheTTL)
+		}
+	}
+	span.SetAttributes(attribute.Int("oula.count", count))
+	if err == nil && value == nil {
+		err = validateCount(count, m.Name)
+	}
+	if err == nil && value == nil && count == 0 && *failOnZero {
+		err = fmt.Errorf("metric %s: count is zero and -failOnZero is set", m.Name)
+	}
+	endSpan(span, err)
+	elapsed := time.Since(queryStarted)
+	if *slowQueryThreshold > 0 && elapsed > *slowQueryThreshold {
+		slog.WarnContext(ctx, "Slow metric query", "metric_name", m.Name, "date", day,
+			"duration_ms", elapsed.Milliseconds(), "threshold", slowQueryThreshold.String(), "query", query, "args", args)
+	}
+	if err != nil {
+		err = redactDSN(err, pgDsns...)
+		slog.ErrorContext(ctx, "Skipping metric", "metric_name", m.Name, "date", day, "error", err)
+		queryErrors.WithLabelValues(m.Name).Inc()
+		return transfer.Result{}, fmt.Errorf("%s: %w", m.Name, err)
+	}
+	r := transfer.Result{Name: m.Name, Table: metricTable(m.MySQLTable), Count: count, Value: value, Upsert: m.Upsert}
+	slog.InfoContext(ctx, "Queried metric", "metric_name", m.Name, "date", day, r.Column(), r.Stored(),
+		"duration_ms", elapsed.Milliseconds())
+	if value == nil {
+		if count == 0 && strings.HasPrefix(m.Name, "active_") {
+			slog.WarnContext(ctx, "Active metric returned zero", "metric_name", m.Name, "date", day)
+		}
+		if err := checkThreshold(m.Name, count, m.MinExpectedValue); err != nil {
+			slog.ErrorContext(ctx, "Metric below expected minimum", "metric_name", m.Name, "date", day, "error", err)
+			alertThresholdBreached(ctx, day, m.Name, err)
+		}
+	}
+	return r, nil
+}
+
+// prepareResults screens results against the previous day's values in
+// sqlDb, when there is one, adds the derived metrics and prints the rows
+// for -outputStdout.
+func prepareResults(ctx context.Context, sqlDb *sql.DB, day string, derived []DerivedMetric, results []transfer.Result) ([]transfer.Result, []error) {
+	var errs []error
+	if sqlDb != nil {
+		var anomalies []error
+		results, anomalies = screenAnomalies(ctx, sqlDb, day, results)
+		errs = append(errs, anomalies...)
+	}
+
+	// Derived metrics are computed from the values that passed the checks.
+	derivedResults, derivedErrs := computeDerived(derived, results)
+	results = append(results, derivedResults...)
+	errs = append(errs, derivedErrs...)
+
+	if *outputStdout {
+		for _, row := range metricRows(day, results) {
+			if err := printMetricJSON(os.Stdout, row); err != nil {
+				slog.ErrorContext(ctx, "Failed to print metric", "metric_name", row.MetricName, "error", err)
+			}
+		}
+	}
+	return results, errs
+}
+
+// exportResults writes results to the file outputs, S3, Kafka, InfluxDB and
+// Redis as configured. The returned error fails every result; the warnings
+// are failed extra copies. toMySQL tells whether the results also went to
+// a MySQL destination.
+func exportResults(ctx context.Context, day string, toMySQL bool, results []transfer.Result) (warnings []error, err error) {
+	defer func() {
+		if err != nil {
+			for _, r := range results {
+				queryErrors.WithLabelValues(r.Name).Inc()
+			}
+		}
+	}()
+
+	if fileOutputEnabled() {
+		if err := writeFileOutputs(day, results); err != nil {
+			return nil, err
+		}
+	}
+
+	// The S3 and Kafka copies are extra unless one is the only destination.
+	if *s3Bucket != "" {
+		if err := uploadToS3(ctx, s3ConfigFromFlags(), metricRows(day, results)); err != nil {
+			if *s3Only {
+				return warnings, err
+			}
+			warnings = append(warnings, err)
+		}
+	}
+	if *kafkaBrokers != "" {
+		if err := publishToKafka(ctx, kafkaConfigFromFlags(), metricRows(day, results)); err != nil {
+			if *kafkaOnly {
+				return warnings, err
+			}
+			warnings = append(warnings, err)
+		}
+	}
+	// InfluxDB and Redis are likewise extra, unless no MySQL destination is
+	// set.
+	if *influxURL != "" {
+		if err := writeToInflux(ctx, influxConfigFromFlags(), metricRows(day, results)); err != nil {
+			if !toMySQL {
+				return warnings, err
+			}
+			warnings = append(warnings, err)
+		}
+	}
+	if *redisAddr != "" {
+		client := newRedisClient()
+		err := writeToRedis(ctx, client, metricRows(day, results))
+		client.Close()
+		if err != nil {
+			if !toMySQL {
+				return warnings, err
+			}
+			warnings = append(warnings, err)
+		}
+	}
+	return warnings, nil
+}
+
+// finishRun counts the written rows, sends the -report and runs the
+// -copyQuery export once the metrics of a run are written.
+func finishRun(ctx context.Context, sqlDb *sql.DB, pgDsns []string, forDate time.Time, written []transfer.Result) []error {
+	day := forDate.Format("2006-01-02")
+	if len(written) > 0 {
+		rowsTransferred.Add(float64(len(written)))
+		if *reportFlag {
+			reportTransfer(ctx, sqlDb, day, written)
+		}
+	}
+	// Row-level exports bypass the metric pipeline and only need the first
+	// source.
+	if *copyQuery != "" && len(pgDsns) > 0 {
+		if err := exportCopyQuery(ctx, pgDsns[0], forDate); err != nil {
+			slog.ErrorContext(ctx, "COPY export failed", "date", day, "error", err)
+			return []error{err}
+		}
+	}
+	return nil
+}
+
+// validateCount rejects counts that cannot be right, so a broken query does
+// not push corrupt values into the MySQL tables.
+func validateCount(count int, name string) error {
+	if count < 0 {
+		return fmt.Errorf("metric %s: count %d is negative", name, count)
+	}
+	if *maxCount > 0 && count > *maxCount {
+		return fmt.Errorf("metric %s: count %d exceeds -maxCount %d", name, count, *maxCount)
+	}
+	return nil
+}
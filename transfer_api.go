@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transferRun is one manually triggered transfer.
+type transferRun struct {
+	ID         string     `json:"id"`
+	Date       string     `json:"date"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Error      *string    `json:"error"`
+}
+
+// runRegistry keeps manually triggered runs in memory. A manual run claims
+// transferInProgress and its tenants, so it cannot overlap another manual or
+// scheduled run.
+type runRegistry struct {
+	mu     sync.Mutex
+	nextID int
+	runs   map[string]*transferRun
+}
+
+var manualRuns = runRegistry{runs: make(map[string]*transferRun)}
+
+var errTransferInProgress = errors.New("a transfer is already in progress")
+
+// start registers a new run and executes it in a goroutine.
+func (reg *runRegistry) start(ctx context.Context, forDate time.Time) (*transferRun, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if err := startOnce(); err != nil {
+		return nil, err
+	}
+	reg.nextID++
+	run := &transferRun{
+		ID:        strconv.Itoa(reg.nextID),
+		Date:      forDate.Format("2006-01-02"),
+		Status:    "running",
+		StartedAt: time.Now(),
+	}
+	reg.runs[run.ID] = run
+	snapshot := *run
+
+	go func() {
+		err := runOnce(ctx, forDate)
+		reg.finish(run.ID, err)
+	}()
+	return &snapshot, nil
+}
+
+func (reg *runRegistry) finish(id string, err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	run := reg.runs[id]
+	now := time.Now()
+	run.FinishedAt = &now
+	run.Status = "succeeded"
+	if err != nil {
+		run.Status = "failed"
+		msg := err.Error()
+		run.Error = &msg
+	}
+	finishOnce()
+}
+
+func (reg *runRegistry) get(id string) (transferRun, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	run, ok := reg.runs[id]
+	if !ok {
+		return transferRun{}, false
+	}
+	return *run, true
+}
+
+type transferRequest struct {
+	Date string `json:"date"`
+}
+
+// newTransferHandler serves POST /transfer and GET /transfer/{id}. Runs use
+// ctx so they stop starting new metrics on shutdown.
+func newTransferHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/transfer"), "/")
+		switch {
+		case id == "" && r.Method == http.MethodPost:
+			handleStartTransfer(ctx, w, r)
+		case id != "" && r.Method == http.MethodGet:
+			run, ok := manualRuns.get(id)
+			if !ok {
+				http.Error(w, "transfer not found", http.StatusNotFound)
+				return
+			}
+			writeJSONResponse(w, http.StatusOK, run)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func handleStartTransfer(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req transferRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	loc, _ := loadTimezone(*timezone)
+	forDate := time.Now().In(loc)
+	if req.Date != "" {
+		var err error
+		if forDate, err = parseDate(req.Date, loc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	run, err := manualRuns.start(ctx, forDate)
+	if errors.Is(err, errTransferInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	slog.Info("Manual transfer triggered", "id", run.ID, "date", run.Date)
+	writeJSONResponse(w, http.StatusAccepted, run)
+}
+
+func writeJSONResponse(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write JSON response", "error", err)
+	}
+}
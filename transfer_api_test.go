@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// useMetrics replaces the loaded metrics for the duration of the test.
+func useMetrics(t testing.TB, queries []MetricQuery, derived []DerivedMetric) {
+	t.Helper()
+	oldQueries, oldDerived := metricQueries, derivedMetrics
+	metricQueries, derivedMetrics = queries, derived
+	t.Cleanup(func() { metricQueries, derivedMetrics = oldQueries, oldDerived })
+}
+
+func TestTransferHandler(t *testing.T) {
+	// A simulated dry run needs neither database.
+	setFlag(t, "simulate", "true")
+	setFlag(t, "dry-run", "true")
+	setFlag(t, "timezone", "UTC")
+	useMetrics(t, []MetricQuery{{Name: "active_machines_count_aleo", Query: "SELECT 1", MySQLTable: "active_machines_count_aleo"}}, nil)
+
+	srv := httptest.NewServer(newTransferHandler(context.Background()))
+	defer srv.Close()
+
+	t.Run("starts a run", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/transfer", "application/json", strings.NewReader(`{"date":"2024-01-15"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+		}
+		var run transferRun
+		if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+			t.Fatal(err)
+		}
+		if run.ID == "" || run.Date != "2024-01-15" || run.Status != "running" {
+			t.Fatalf("run = %+v, want a running run for 2024-01-15", run)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			resp, err := http.Get(srv.URL + "/transfer/" + run.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got transferRun
+			err = json.NewDecoder(resp.Body).Decode(&got)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != "running" {
+				if got.Status != "succeeded" || got.FinishedAt == nil {
+					t.Fatalf("run = %+v, want succeeded", got)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("run did not finish")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("conflicts with a running transfer", func(t *testing.T) {
+		if !startTransfer() {
+			t.Fatal("a transfer is still in progress")
+		}
+		defer finishTransfer()
+		resp, err := http.Post(srv.URL+"/transfer", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusConflict)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"invalid body", http.MethodPost, "/transfer", `{"date":`, http.StatusBadRequest},
+		{"invalid date", http.MethodPost, "/transfer", `{"date":"2024-02-30"}`, http.StatusBadRequest},
+		{"future date", http.MethodPost, "/transfer", `{"date":"2999-01-01"}`, http.StatusBadRequest},
+		{"unknown run", http.MethodGet, "/transfer/999", "", http.StatusNotFound},
+		{"wrong method", http.MethodDelete, "/transfer/1", "", http.StatusMethodNotAllowed},
+		{"list not supported", http.MethodGet, "/transfer", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransferHandlerTenants(t *testing.T) {
+	setFlag(t, "timezone", "UTC")
+	useMetrics(t, mockMetrics, nil)
+	pgDSN, mysqlDSN, pgMock, mysqlMock := mockTransfer(t)
+	// The top-level DSNs stay empty, so only the tenant's databases work.
+	oldTenants := tenants
+	tenants = []Tenant{{Name: "a", PgDsn: pgDSN, MysqlDsn: mysqlDSN}}
+	t.Cleanup(func() { tenants = oldTenants })
+	expectSuccessfulTransfer(pgMock, mysqlMock, false)
+
+	srv := httptest.NewServer(newTransferHandler(context.Background()))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/transfer", "application/json", strings.NewReader(`{"date":"2024-01-15"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var run transferRun
+	err = json.NewDecoder(resp.Body).Decode(&run)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := manualRuns.get(run.ID)
+		if got.Status != "running" {
+			if got.Status != "succeeded" {
+				t.Fatalf("run = %+v, want succeeded", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("run did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, mock := range []sqlmock.Sqlmock{pgMock, mysqlMock} {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTenantTransferLock(t *testing.T) {
+	setFlag(t, "timezone", "UTC")
+	useMetrics(t, mockMetrics, nil)
+	pgDSN, mysqlDSN, pgMock, mysqlMock := mockTransfer(t)
+	oldTenants := tenants
+	tenants = []Tenant{{Name: "a", PgDsn: pgDSN, MysqlDsn: mysqlDSN}}
+	t.Cleanup(func() { tenants = oldTenants })
+	expectSuccessfulTransfer(pgMock, mysqlMock, false)
+	ctx := withTenant(context.Background(), &tenants[0])
+	forDate := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	if !startTenantTransfer("a") {
+		t.Fatal("tenant a is still in progress")
+	}
+	srv := httptest.NewServer(newTransferHandler(context.Background()))
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/transfer", "application/json", strings.NewReader(`{"date":"2024-01-15"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("status = %d, want %d while the tenant is busy", resp.StatusCode, http.StatusConflict)
+	}
+	if !startTransfer() {
+		t.Fatal("a rejected manual run kept transferInProgress")
+	}
+	finishTransfer()
+
+	// A scheduled run of the busy tenant is skipped, leaving the expected
+	// queries unused.
+	runScheduledTransfer(ctx, forDate)
+	if err := pgMock.ExpectationsWereMet(); err == nil {
+		t.Fatal("scheduled run of a busy tenant ran")
+	}
+
+	finishTenantTransfer("a")
+	runScheduledTransfer(ctx, forDate)
+	for _, mock := range []sqlmock.Sqlmock{pgMock, mysqlMock} {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+	if !startTenantTransfer("a") {
+		t.Error("scheduled run kept the tenant claimed")
+	}
+	finishTenantTransfer("a")
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"oula-transfer/internal/transfer"
+)
+
+// TestTransferData runs a transfer for 2024-01-15 against the PostgreSQL
+// seeded from testdata/pg_seed.sql and checks the values written to MySQL.
+// It needs the databases from testdata/docker-compose.yml; see
+// `make test-integration`.
+func TestTransferData(t *testing.T) {
+	pgDSN, mysqlDSN := os.Getenv("OULA_TEST_PG_DSN"), os.Getenv("OULA_TEST_MYSQL_DSN")
+	if pgDSN == "" || mysqlDSN == "" {
+		t.Skip("OULA_TEST_PG_DSN and OULA_TEST_MYSQL_DSN are not set")
+	}
+	queries, err := loadMetricQueries("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries, derived, err := splitDerivedMetrics(queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useMetrics(t, queries, derived)
+	// Upserting keeps the test repeatable against the same stack.
+	setFlag(t, "upsert", "true")
+
+	ctx := context.Background()
+	if err := migrateAll(ctx, []string{mysqlDSN}, queries, derived); err != nil {
+		t.Fatalf("migrateAll: %v", err)
+	}
+	res, err := transferData(ctx, []string{pgDSN}, []string{mysqlDSN}, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("transferData: %v", err)
+	}
+	if res.MetricsFailed > 0 {
+		t.Errorf("metrics failed: %v", res.FailedMetrics)
+	}
+
+	db, err := sql.Open("mysql", mysqlDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		table  string
+		column string
+		want   string
+	}{
+		{"active_machines_count_aleo", "count", "2"},
+		{"active_machines_count_quai", "count", "1"},
+		{"active_machines_count_total", "count", "3"},
+		{"active_projects_count", "count", "2"},
+		{"active_channel_machines_count_aleo", "count", "1"},
+		{"active_channel_machines_count_quai", "count", "0"},
+		{"new_users_count", "count", "1"},
+		{"lost_users_count", "count", "1"},
+		{"total_users_count", "count", "3"},
+		{"dormant_accounts_count", "count", "1"},
+		{"avg_machines_per_user", "value", "1.50"},
+		{"p50_machines_per_user", "value", "1.50"},
+		{"p95_machines_per_user", "value", "1.95"},
+		{"channel_machines_fraction_aleo", "value", "0.5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			var got string
+			err := db.QueryRow("SELECT " + tt.column + " FROM " + tt.table + " WHERE date = '2024-01-15'").Scan(&got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s.%s = %s, want %s", tt.table, tt.column, got, tt.want)
+			}
+		})
+	}
+}
+
+// mockTransfer makes transferData open sqlmock handles and returns their
+// DSNs and mocks. Queries run one at a time so the PostgreSQL expectations
+// are met in order.
+func mockTransfer(t testing.TB) (pgDSN, mysqlDSN string, pgMock, mysqlMock sqlmock.Sqlmock) {
+	t.Helper()
+	oldPg, oldMySQL := pgDriver, mysqlDriver
+	pgDriver, mysqlDriver = "sqlmock", "sqlmock"
+	t.Cleanup(func() { pgDriver, mysqlDriver = oldPg, oldMySQL })
+	setFlag(t, "workers", "1")
+	setFlag(t, "pgMaxOpenConns", "1")
+	setFlag(t, "mysqlMaxOpenConns", "1")
+	setFlag(t, "anomalyThreshold", "0")
+
+	pgDSN, mysqlDSN = t.Name()+"/pg", t.Name()+"/mysql"
+	pgDB, pgMock := newMockDSN(t, pgDSN)
+	mysqlDB, mysqlMock := newMockDSN(t, mysqlDSN)
+	t.Cleanup(func() {
+		pgDB.Close()
+		mysqlDB.Close()
+	})
+	return pgDSN, mysqlDSN, pgMock, mysqlMock
+}
+
+// newMockDSN registers a literal-matching sqlmock under dsn. The returned
+// handle keeps the mock registered until it is closed.
+func newMockDSN(t testing.TB, dsn string) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+const (
+	mockQueryA = "SELECT count(*) FROM machine WHERE project = 'ALEO' AND day = $1::date"
+	mockQueryB = "SELECT count(*) FROM machine WHERE project = 'Quai' AND day = $1::date"
+
+	schemaQuery    = "SELECT 1 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	insertRunQuery = "INSERT INTO transfer_runs (transfer_date, started_at, status) VALUES (?, ?, ?)"
+	updateRunQuery = "UPDATE transfer_runs SET completed_at = ?, status = ?, metrics_succeeded = ?, metrics_failed = ?, error_message = ? WHERE id = ?"
+)
+
+var mockMetrics = []MetricQuery{
+	{Name: "metric_a", Query: mockQueryA, MySQLTable: "metric_a"},
+	{Name: "metric_b", Query: mockQueryB, MySQLTable: "metric_b"},
+}
+
+// expectTransferStart sets up the schema check and the transfer_runs row
+// every run against MySQL begins with.
+func expectTransferStart(mock sqlmock.Sqlmock) {
+	for _, m := range mockMetrics {
+		mock.ExpectQuery(schemaQuery).WithArgs(m.MySQLTable).
+			WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	}
+	mock.ExpectExec(insertRunQuery).WithArgs("2024-01-15", sqlmock.AnyArg(), runStatusRunning).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+}
+
+func expectCount(mock sqlmock.Sqlmock, query string, count int64) {
+	mock.ExpectQuery(query).WithArgs("2024-01-15").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+}
+
+// expectSuccessfulTransfer sets up a run in which both metrics are queried
+// and written.
+func expectSuccessfulTransfer(pg, my sqlmock.Sqlmock, upsert bool) {
+	expectTransferStart(my)
+	expectCount(pg, mockQueryA, 3)
+	expectCount(pg, mockQueryB, 5)
+	my.ExpectBegin()
+	my.ExpectExec(transfer.InsertStatement("metric_a", "count", upsert)).WithArgs("2024-01-15", 3).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	my.ExpectExec(transfer.InsertStatement("metric_b", "count", upsert)).WithArgs("2024-01-15", 5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	my.ExpectCommit()
+	my.ExpectExec(updateRunQuery).WithArgs(sqlmock.AnyArg(), runStatusSuccess, 2, 0, nil, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	pg.ExpectClose()
+	my.ExpectClose()
+}
+
+func TestTransferDataMocked(t *testing.T) {
+	tests := []struct {
+		name          string
+		upsert        bool
+		expect        func(pg, my sqlmock.Sqlmock)
+		wantErr       bool
+		wantSucceeded int
+	}{
+		{
+			name: "happy path",
+			expect: func(pg, my sqlmock.Sqlmock) {
+				expectSuccessfulTransfer(pg, my, false)
+			},
+			wantSucceeded: 2,
+		},
+		{
+			name: "PostgreSQL failure",
+			expect: func(pg, my sqlmock.Sqlmock) {
+				expectTransferStart(my)
+				expectCount(pg, mockQueryA, 3)
+				pg.ExpectQuery(mockQueryB).WithArgs("2024-01-15").
+					WillReturnError(&pgconn.PgError{Code: "42P01", Message: `relation "machine" does not exist`})
+				my.ExpectBegin()
+				my.ExpectExec(transfer.InsertStatement("metric_a", "count", false)).WithArgs("2024-01-15", 3).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				my.ExpectCommit()
+				my.ExpectExec(updateRunQuery).WithArgs(sqlmock.AnyArg(), runStatusPartial, 1, 1, sqlmock.AnyArg(), 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				pg.ExpectClose()
+				my.ExpectClose()
+			},
+			wantErr:       true,
+			wantSucceeded: 1,
+		},
+		{
+			name: "MySQL insert failure",
+			expect: func(pg, my sqlmock.Sqlmock) {
+				expectTransferStart(my)
+				expectCount(pg, mockQueryA, 3)
+				expectCount(pg, mockQueryB, 5)
+				my.ExpectBegin()
+				my.ExpectExec(transfer.InsertStatement("metric_a", "count", false)).WithArgs("2024-01-15", 3).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				my.ExpectExec(transfer.InsertStatement("metric_b", "count", false)).WithArgs("2024-01-15", 5).
+					WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '2024-01-15' for key 'PRIMARY'"})
+				my.ExpectRollback()
+				my.ExpectExec(updateRunQuery).WithArgs(sqlmock.AnyArg(), runStatusFailure, 0, 2, sqlmock.AnyArg(), 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				pg.ExpectClose()
+				my.ExpectClose()
+			},
+			wantErr: true,
+		},
+		{
+			name:   "upsert",
+			upsert: true,
+			expect: func(pg, my sqlmock.Sqlmock) {
+				expectSuccessfulTransfer(pg, my, true)
+			},
+			wantSucceeded: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMetrics(t, mockMetrics, nil)
+			if tt.upsert {
+				setFlag(t, "upsert", "true")
+			}
+			pgDSN, mysqlDSN, pgMock, mysqlMock := mockTransfer(t)
+			tt.expect(pgMock, mysqlMock)
+
+			res, err := transferData(context.Background(), []string{pgDSN}, []string{mysqlDSN}, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("transferData error = %v, want error %v", err, tt.wantErr)
+			}
+			if res.MetricsSucceeded != tt.wantSucceeded || res.MetricsFailed != len(mockMetrics)-tt.wantSucceeded {
+				t.Errorf("succeeded/failed = %d/%d, want %d/%d",
+					res.MetricsSucceeded, res.MetricsFailed, tt.wantSucceeded, len(mockMetrics)-tt.wantSucceeded)
+			}
+			if err := pgMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("PostgreSQL: %v", err)
+			}
+			if err := mysqlMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("MySQL: %v", err)
+			}
+		})
+	}
+}
+
+// BenchmarkTransferData measures a full two-metric run against sqlmock.
+// Each iteration gets fresh mocks, since sqlmock scans every expectation
+// it has ever been given.
+func BenchmarkTransferData(b *testing.B) {
+	useMetrics(b, mockMetrics, nil)
+	mockTransfer(b)
+	forDate := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		pgDSN, mysqlDSN := fmt.Sprintf("%s/pg/%d", b.Name(), i), fmt.Sprintf("%s/mysql/%d", b.Name(), i)
+		pgDB, pgMock := newMockDSN(b, pgDSN)
+		mysqlDB, mysqlMock := newMockDSN(b, mysqlDSN)
+		expectSuccessfulTransfer(pgMock, mysqlMock, false)
+		b.StartTimer()
+
+		if _, err := transferData(context.Background(), []string{pgDSN}, []string{mysqlDSN}, forDate); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		if err := pgMock.ExpectationsWereMet(); err != nil {
+			b.Fatalf("PostgreSQL: %v", err)
+		}
+		if err := mysqlMock.ExpectationsWereMet(); err != nil {
+			b.Fatalf("MySQL: %v", err)
+		}
+		pgDB.Close()
+		mysqlDB.Close()
+		b.StartTimer()
+	}
+}
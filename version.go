@@ -0,0 +1,27 @@
+package main
+
+import "fmt"
+
+// Build information, injected at link time:
+//
+//	go build -ldflags "-X main.Version=v1.2.3 -X main.Commit=abc1234 -X main.BuildTime=2024-01-15T10:00:00Z"
+//
+// The Makefile's build target sets all three from git.
+var (
+	Version   string
+	Commit    string
+	BuildTime string
+)
+
+// orUnknown returns s, or "unknown" for a binary built without -ldflags.
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+func versionString() string {
+	return fmt.Sprintf("oula-transfer version %s, commit %s, built %s",
+		orUnknown(Version), orUnknown(Commit), orUnknown(BuildTime))
+}
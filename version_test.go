@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// setBuildInfo sets the link-time build variables for the duration of the
+// test.
+func setBuildInfo(t *testing.T, version, commit, buildTime string) {
+	t.Helper()
+	oldVersion, oldCommit, oldBuildTime := Version, Commit, BuildTime
+	Version, Commit, BuildTime = version, commit, buildTime
+	t.Cleanup(func() { Version, Commit, BuildTime = oldVersion, oldCommit, oldBuildTime })
+}
+
+func TestVersionString(t *testing.T) {
+	tests := []struct {
+		version, commit, buildTime string
+		want                       string
+	}{
+		{"v1.2.3", "abc1234", "2024-01-15T10:00:00Z", "oula-transfer version v1.2.3, commit abc1234, built 2024-01-15T10:00:00Z"},
+		{"", "", "", "oula-transfer version unknown, commit unknown, built unknown"},
+		{"v1.2.3", "", "", "oula-transfer version v1.2.3, commit unknown, built unknown"},
+	}
+	for _, tt := range tests {
+		setBuildInfo(t, tt.version, tt.commit, tt.buildTime)
+		if got := versionString(); got != tt.want {
+			t.Errorf("versionString() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestVersionFlag(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-version")
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("-version: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(out)), "oula-transfer version unknown, commit unknown, built unknown"; got != want {
+		t.Errorf("-version printed %q, want %q", got, want)
+	}
+}
+
+func TestStatusIncludesVersion(t *testing.T) {
+	setBuildInfo(t, "v1.2.3", "abc1234", "2024-01-15T10:00:00Z")
+	rec := httptest.NewRecorder()
+	handleStatus(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var resp statusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != "v1.2.3" || resp.Commit != "abc1234" || resp.BuildTime != "2024-01-15T10:00:00Z" {
+		t.Errorf("/status build info = %q %q %q", resp.Version, resp.Commit, resp.BuildTime)
+	}
+}